@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// audit actions
+const (
+	auditDeleted      = "deleted"       // the source object was deleted
+	auditArchived     = "archived"      // the source object was moved to the archive
+	auditQuarantined  = "quarantined"   // the source object could not be processed and was moved to quarantine
+	auditRefused      = "refused"       // deletion was refused because the output could not be verified
+	auditDeleteFailed = "delete-failed" // deletion was permitted but failed
+)
+
+// DeletionRecord describes a source object deletion (or refused deletion). Archive is the location of the
+// copy when the object was archived or quarantined
+type DeletionRecord struct {
+	Time           time.Time `json:"time"`
+	Job            string    `json:"job"`
+	Action         string    `json:"action"`
+	Stage          string    `json:"stage,omitempty"`
+	SourceBucket   string    `json:"source_bucket"`
+	SourceKey      string    `json:"source_key"`
+	SourceETag     string    `json:"source_etag"`
+	SourceSize     int64     `json:"source_size"`
+	SourceChecksum string    `json:"source_checksum"`
+	Output         string    `json:"output,omitempty"`
+	OutputSize     int64     `json:"output_size"`
+	Archive        string    `json:"archive,omitempty"`
+	Verified       bool      `json:"verified"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only record of source deletions
+type AuditLog interface {
+	Append(rec DeletionRecord) error
+}
+
+// the audit log specification that writes to standard error
+const auditLogStderr = "stderr"
+
+// create an audit log from a specification of the form 's3://bucket[/prefix]', an absolute filename or
+// 'stderr'. An empty specification disables auditing
+func newAuditLog(spec string, objects ObjectStore) (AuditLog, error) {
+
+	if len(spec) == 0 {
+		return nullAuditLog{}, nil
+	}
+
+	if spec == auditLogStderr {
+		return &writerAuditLog{w: os.Stderr}, nil
+	}
+
+	dest, err := parseDestination(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dest.Bucket) != 0 {
+		return &objectAuditLog{objects: objects, dest: dest}, nil
+	}
+
+	// ensure we can append to the file now rather than when we first delete something
+	f, err := os.OpenFile(dest.FSRoot, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+	return &fileAuditLog{filename: dest.FSRoot}, nil
+}
+
+// fileAuditLog appends one JSON record per line to a local file
+type fileAuditLog struct {
+	sync.Mutex
+	filename string
+}
+
+func (a *fileAuditLog) Append(rec DeletionRecord) error {
+
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	f, err := os.OpenFile(a.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(buf, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// writerAuditLog writes one JSON record per line to a stream (normally standard error)
+type writerAuditLog struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (a *writerAuditLog) Append(rec DeletionRecord) error {
+
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	_, err = a.w.Write(append(buf, '\n'))
+	return err
+}
+
+// objectAuditLog writes each record as a new object below a prefix, objects are never overwritten
+type objectAuditLog struct {
+	objects ObjectStore
+	dest    Destination
+}
+
+func (a *objectAuditLog) Append(rec DeletionRecord) error {
+
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// partitioned by day and named so a listing is in time order
+	name := fmt.Sprintf("%s/%s-%s-%s.json", rec.Time.UTC().Format("2006/01/02"),
+		rec.Time.UTC().Format("20060102T150405.000Z"), rec.Job, rec.Action)
+	return a.objects.PutFromBuffer(a.dest.Bucket, path.Join(a.dest.KeyPrefix, name), buf)
+}
+
+// nullAuditLog is used when auditing is disabled
+type nullAuditLog struct{}
+
+func (nullAuditLog) Append(rec DeletionRecord) error { return nil }
+
+//
+// end of file
+//
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// MessageSource supplies the inbound messages
+type MessageSource interface {
+
+	// Receive up to max messages, waiting up to wait for at least one to become available
+	Receive(max uint, wait time.Duration) ([]awssqs.Message, error)
+
+	// Ready returns an error if the source is not reachable
+	Ready() error
+}
+
+// MessageAcker acknowledges messages once they have been processed so they are not redelivered
+type MessageAcker interface {
+	Ack(receiptHandle awssqs.ReceiptHandle) error
+}
+
+// MessagePublisher sends messages
+type MessagePublisher interface {
+	Publish(payload []byte, attributes map[string]string) error
+}
+
+// a queue that can be consumed from and published to
+type messageQueue interface {
+	MessageSource
+	MessageAcker
+	MessagePublisher
+}
+
+// open a queue by name, either an SQS queue name or file:///path for a local directory queue. The visibility
+// timeout only applies to a local queue that is received from (an SQS queue has its own)
+func openQueue(name string, visibility time.Duration) (messageQueue, error) {
+
+	if strings.HasPrefix(name, localScheme) == true {
+		return newDirQueue(strings.TrimPrefix(name, localScheme), visibility)
+	}
+
+	aws, err := sqsClient()
+	if err != nil {
+		return nil, err
+	}
+	return newSqsQueue(aws, name)
+}
+
+// open the configured object store, either 's3' or file:///path for a local filesystem store
+func openObjectStore(config ServiceConfig) (ObjectStore, error) {
+
+	if strings.HasPrefix(config.ObjectStore, localScheme) == true {
+		return newFSObjectStore(strings.TrimPrefix(config.ObjectStore, localScheme))
+	}
+
+	if config.ObjectStore != "s3" {
+		return nil, fmt.Errorf("unsupported object store '%s'", config.ObjectStore)
+	}
+	return newS3ObjectStore(config)
+}
+
+// is the backend specification a valid local one (or not a local one at all)
+func validLocalSpec(spec string) bool {
+	if strings.HasPrefix(spec, localScheme) == false {
+		return true
+	}
+	return filepath.IsAbs(strings.TrimPrefix(spec, localScheme))
+}
+
+// ObjectStore is the storage for inbound, converted, archived and quarantined objects
+type ObjectStore interface {
+
+	// StatObject returns the size of an object
+	StatObject(bucket string, key string) (int64, error)
+
+	// GetToFile downloads an object to a local file
+	GetToFile(bucket string, key string, filename string) error
+
+	// GetReader opens an object for streaming, the caller must close it
+	GetReader(bucket string, key string) (io.ReadCloser, error)
+
+	// PutFromFile uploads a local file
+	PutFromFile(bucket string, key string, filename string, opts PutOptions) error
+
+	// PutFromReader uploads the contents of a reader of unknown length. If the reader returns an error the
+	// upload is abandoned and nothing is written
+	PutFromReader(bucket string, key string, r io.Reader, opts PutOptions) error
+
+	// PutFromBuffer uploads the contents of a buffer
+	PutFromBuffer(bucket string, key string, buf []byte) error
+
+	// DeleteObject removes an object
+	DeleteObject(bucket string, key string) error
+
+	// CopyObject copies an object, optionally to a different storage class
+	CopyObject(srcBucket string, srcKey string, dstBucket string, dstKey string, storageClass string) error
+
+	// TagObject adds (or replaces) a tag on an object, preserving any existing tags
+	TagObject(bucket string, key string, tagKey string, tagValue string) error
+
+	// UpdateMetadata replaces the metadata, content type, cache control and storage class of an object with
+	// those of the options, keeping its contents and tags. The checksums are verified where the store can
+	UpdateMetadata(bucket string, key string, opts PutOptions) error
+}
+
+// PutOptions are the optional details of an upload
+type PutOptions struct {
+	SHA256   string            // the hex encoded SHA-256 of the content, the store verifies it where it can
+	MD5      string            // the hex encoded MD5 of the content, the store verifies it where it can
+	Metadata map[string]string // user metadata stored with the object
+
+	ContentType  string            // the content type (empty for the store default)
+	CacheControl string            // the Cache-Control header served with the object
+	StorageClass string            // the storage class (empty for the bucket default)
+	Tags         map[string]string // the object tags
+}
+
+//
+// end of file
+//
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// the object metadata keys holding the output checksums (x-amz-meta-sha256 and x-amz-meta-md5 in S3)
+var metadataSHA256 = "sha256"
+var metadataMD5 = "md5"
+
+// contentChecksums are the hex encoded checksums of some content, the MD5 is empty unless requested
+type contentChecksums struct {
+	SHA256 string
+	MD5    string
+}
+
+// the upload options that have the store verify the checksums and record them in the object metadata, empty
+// when the checksums are not known
+func (c contentChecksums) putOptions() PutOptions {
+	opts := PutOptions{SHA256: c.SHA256, MD5: c.MD5}
+	if len(c.SHA256) != 0 || len(c.MD5) != 0 {
+		opts.Metadata = make(map[string]string)
+	}
+	if len(c.SHA256) != 0 {
+		opts.Metadata[metadataSHA256] = c.SHA256
+	}
+	if len(c.MD5) != 0 {
+		opts.Metadata[metadataMD5] = c.MD5
+	}
+	return opts
+}
+
+// checksumWriter computes the checksums of everything written to it
+type checksumWriter struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newChecksumWriter(withMD5 bool) *checksumWriter {
+	w := &checksumWriter{sha256: sha256.New()}
+	if withMD5 == true {
+		w.md5 = md5.New()
+	}
+	return w
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	w.sha256.Write(p)
+	if w.md5 != nil {
+		w.md5.Write(p)
+	}
+	return len(p), nil
+}
+
+func (w *checksumWriter) sums() contentChecksums {
+	c := contentChecksums{SHA256: hex.EncodeToString(w.sha256.Sum(nil))}
+	if w.md5 != nil {
+		c.MD5 = hex.EncodeToString(w.md5.Sum(nil))
+	}
+	return c
+}
+
+// ensure the checksums match those expected, an empty expected checksum is not checked
+func (w *checksumWriter) verify(opts PutOptions) error {
+	sums := w.sums()
+	if len(opts.SHA256) != 0 && opts.SHA256 != sums.SHA256 {
+		return fmt.Errorf("sha256 mismatch, expected %s got %s", opts.SHA256, sums.SHA256)
+	}
+	if len(opts.MD5) != 0 && w.md5 != nil && opts.MD5 != sums.MD5 {
+		return fmt.Errorf("md5 mismatch, expected %s got %s", opts.MD5, sums.MD5)
+	}
+	return nil
+}
+
+// the checksums of a file
+func fileChecksums(filename string, withMD5 bool) (contentChecksums, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return contentChecksums{}, err
+	}
+	defer f.Close()
+
+	w := newChecksumWriter(withMD5)
+	_, err = io.Copy(w, f)
+	if err != nil {
+		return contentChecksums{}, err
+	}
+	return w.sums(), nil
+}
+
+// OutputSidecar describes a filesystem output, it is written alongside it as <output>.json
+type OutputSidecar struct {
+	Job          string    `json:"job"`
+	Time         time.Time `json:"time"`
+	SourceBucket string    `json:"source_bucket"`
+	SourceKey    string    `json:"source_key"`
+	Output       string    `json:"output"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	MD5          string    `json:"md5,omitempty"`
+}
+
+// write the sidecar for a filesystem output
+func writeOutputSidecar(outputFile string, sidecar OutputSidecar) error {
+	buf, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeLocalFile(fmt.Sprintf("%s.json", outputFile), buf)
+}
+
+// S3 expects digests base64 encoded, an invalid hex digest gives an empty result
+func base64Digest(hexDigest string) string {
+	raw, err := hex.DecodeString(hexDigest)
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestChecksumPutOptions(t *testing.T) {
+
+	tests := []struct {
+		name string
+		sums contentChecksums
+	}{
+		{"none", contentChecksums{}},
+		{"sha256", contentChecksums{SHA256: testDataSHA256}},
+		{"md5", contentChecksums{MD5: testDataMD5}},
+		{"both", contentChecksums{SHA256: testDataSHA256, MD5: testDataMD5}},
+	}
+	for _, tt := range tests {
+		opts := tt.sums.putOptions()
+		if opts.SHA256 != tt.sums.SHA256 || opts.MD5 != tt.sums.MD5 ||
+			opts.Metadata[metadataSHA256] != tt.sums.SHA256 || opts.Metadata[metadataMD5] != tt.sums.MD5 {
+			t.Errorf("%s: unexpected options %+v", tt.name, opts)
+		}
+		if (len(opts.Metadata) == 0) != (tt.sums == contentChecksums{}) {
+			t.Errorf("%s: unexpected metadata %v", tt.name, opts.Metadata)
+		}
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// run a subcommand and return the process exit status
+func runCommand(args []string) int {
+
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		return configCheckCommand(args[2:])
+	}
+
+	if len(args) >= 2 && args[0] == "jobs" && args[1] == "list" {
+		return jobsListCommand(args[2:])
+	}
+
+	if len(args) >= 2 && args[0] == "jobs" && args[1] == "show" {
+		return jobsShowCommand(args[2:])
+	}
+
+	if len(args) >= 1 && args[0] == "submit" {
+		return submitCommand(args[1:])
+	}
+
+	if len(args) >= 1 && args[0] == "healthcheck" {
+		return healthcheckCommand(args[1:])
+	}
+
+	usage()
+	return 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s                           run the service\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s config check [-file name] validate the configuration\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s healthcheck [-ready]      check the health of a running service\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s jobs list [-bucket name] [-prefix prefix] [-outcome outcome] [-limit n]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %*s                          list the job history\n", len(os.Args[0]), "")
+	fmt.Fprintf(os.Stderr, "       %s jobs show <id>            show a single job\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s submit <file> <bucket/key> add a file to the local object store and queue\n", os.Args[0])
+}
+
+// validate the configuration (environment and optional configuration file) and report all the problems found
+func configCheckCommand(args []string) int {
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	filename := fs.String("file", "", "the configuration file (overrides IIIF_INGEST_CONFIG_FILE)")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if len(*filename) != 0 {
+		_ = os.Setenv("IIIF_INGEST_CONFIG_FILE", *filename)
+	}
+
+	cfg, errs := loadConfiguration()
+	if len(errs) != 0 {
+		fmt.Printf("configuration is INVALID, %d problem(s) found:\n", len(errs))
+		for _, e := range errs {
+			fmt.Printf("  %s\n", e.Error())
+		}
+		return 1
+	}
+
+	for _, line := range cfg.describe() {
+		fmt.Printf("%s\n", line)
+	}
+	fmt.Printf("configuration is valid\n")
+	return 0
+}
+
+// check the health (or readiness) of the running service, suitable for a container HEALTHCHECK
+func healthcheckCommand(args []string) int {
+
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	ready := fs.Bool("ready", false, "check readiness rather than liveness")
+	timeout := fs.Duration("timeout", 5*time.Second, "the request timeout")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	listen, err := commandSetting("IIIF_INGEST_HTTP_LISTEN", ":8080")
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	if len(listen) == 0 {
+		fmt.Printf("HTTP server is disabled (IIIF_INGEST_HTTP_LISTEN)\n")
+		return 1
+	}
+
+	endpoint := "/healthz"
+	if *ready == true {
+		endpoint = "/readyz"
+	}
+
+	client := http.Client{Timeout: *timeout}
+	resp, err := client.Get(healthURL(listen, endpoint))
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s", body)
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
+
+// add a file to the local object store and send the corresponding S3 event to the local inbound queue, so the
+// pipeline can be exercised without AWS
+func submitCommand(args []string) int {
+
+	if len(args) != 2 {
+		usage()
+		return 2
+	}
+
+	s := strings.SplitN(args[1], "/", 2)
+	if len(s) != 2 || len(s[0]) == 0 || len(s[1]) == 0 {
+		fmt.Printf("destination must be bucket/key\n")
+		return 2
+	}
+	bucket, key := s[0], s[1]
+
+	storeSpec, err := commandSetting("IIIF_INGEST_OBJECT_STORE", "s3")
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	queueName, _ := commandSetting("IIIF_INGEST_IN_QUEUE", "")
+	if strings.HasPrefix(storeSpec, localScheme) == false || strings.HasPrefix(queueName, localScheme) == false {
+		fmt.Printf("submit requires a local object store (IIIF_INGEST_OBJECT_STORE) and queue (IIIF_INGEST_IN_QUEUE)\n")
+		return 1
+	}
+
+	objects, err := newFSObjectStore(strings.TrimPrefix(storeSpec, localScheme))
+	if err == nil {
+		err = objects.PutFromFile(bucket, key, args[0], PutOptions{})
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	queue, err := openQueue(queueName, 0)
+	if err == nil {
+		var payload []byte
+		payload, err = json.Marshal(s3Event(bucket, key, fileSize(args[0])))
+		if err == nil {
+			err = queue.Publish(payload, nil)
+		}
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	fmt.Printf("submitted %s as %s/%s\n", args[0], bucket, key)
+	return 0
+}
+
+// lookup a single setting the way the service does, environment variables override the configuration file
+func commandSetting(env string, defaultValue string) (string, error) {
+	l, _, err := newConfigLoader()
+	if err != nil {
+		return "", fmt.Errorf("loading configuration file (%s)", err.Error())
+	}
+	return l.envWithDefault(env, defaultValue), nil
+}
+
+// open the job store configured in the environment or configuration file
+func openJobStore() (JobStore, error) {
+	spec, err := commandSetting("IIIF_INGEST_JOB_STORE", "")
+	if err != nil {
+		return nil, err
+	}
+	store, err := newJobStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// list the job history, most recent first
+func jobsListCommand(args []string) int {
+
+	fs := flag.NewFlagSet("jobs list", flag.ContinueOnError)
+	bucket := fs.String("bucket", "", "the source bucket")
+	prefix := fs.String("prefix", "", "the source key prefix")
+	outcome := fs.String("outcome", "", "the job outcome (completed, failed, deferred or retried)")
+	limit := fs.Int("limit", 50, "the maximum number of jobs (0 for all)")
+	asJSON := fs.Bool("json", false, "output JSON")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	store, err := openJobStore()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	defer store.Close()
+
+	jobs, err := store.List(JobFilter{SourceBucket: *bucket, KeyPrefix: *prefix, Outcome: *outcome, Limit: *limit})
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	if *asJSON == true {
+		return printJSON(jobs)
+	}
+
+	for _, j := range jobs {
+		fmt.Printf("%s  %s  %-9s  %d  s3://%s/%s\n", j.Id, j.Started.Format(time.RFC3339), j.Outcome, j.Attempts,
+			j.SourceBucket, j.SourceKey)
+	}
+	return 0
+}
+
+// show a single job
+func jobsShowCommand(args []string) int {
+
+	if len(args) != 1 {
+		usage()
+		return 2
+	}
+
+	store, err := openJobStore()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	defer store.Close()
+
+	job, err := store.Get(args[0])
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	if job == nil {
+		fmt.Printf("job %s not found\n", args[0])
+		return 1
+	}
+	return printJSON(job)
+}
+
+func printJSON(v interface{}) int {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+	fmt.Printf("%s\n", buf)
+	return 0
+}
+
+//
+// end of file
+//
@@ -2,107 +2,489 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"mime"
+	"net/url"
 	"os"
-	"regexp"
+	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 var maxNameRegex = 32
 var maxConvertOptions = 32
+var maxOutputRoutes = 32
+var maxDiskFactors = 32
+var maxLanes = 8
+var maxOutputMetadata = 32
+var maxContentTypes = 32
+var maxOutputTargets = 8
 
 // ServiceConfig defines all the service configuration parameters
 type ServiceConfig struct {
 
 	// service configuration
-	InQueueName     string // SQS queue name for inbound documents
+	InQueueName     string // SQS queue name for inbound documents (or file:///path for a local queue)
+	EventQueueName  string // SQS queue name for outbound events (or file:///path, empty to disable)
+	ObjectStore     string // the object store (s3 or file:///path for a local store)
+	S3Endpoint      string // the S3 endpoint URL for S3 compatible stores (empty for AWS)
+	S3Region        string // the S3 region (empty for the SDK default)
+	S3PathStyle     bool   // use path style addressing (required by most S3 compatible stores)
+	S3AccessKey     string // static S3 credentials (empty for the SDK default credential chain)
+	S3SecretKey     string // static S3 credentials
+	S3PartSize      int    // the multipart upload and ranged download part size (in MB), larger outputs are not checksum verified by S3
+	S3Concurrency   int    // the number of parts transferred at once for each object
+	S3MaxRetries    int    // how many times a failed S3 request (or part) is retried
 	PollTimeOut     int64  // the SQS queue timeout (in seconds)
 	LocalWorkDir    string // the local work directory
 	WorkerQueueSize int    // the inbound message queue size to feed the workers
+	ReceiveBatch    int    // the maximum number of messages received at once (1 to 10)
 	Workers         int    // the number of worker processes
+	Lanes           []Lane // the worker lanes by expected size (empty for a single lane)
+	AdaptiveWorkers bool   // adapt the number of running workers to the CPU and memory headroom
+	MinWorkers      int    // the minimum number of running workers when adaptive
+	AdaptInterval   int    // how often the adaptive workers are adjusted (in seconds)
+	CPUHighWater    int    // the CPU utilization above which adaptive workers are reduced (percent)
+	MemoryHighWater int    // the memory utilization above which adaptive workers are reduced (percent)
+	HttpListen      string // the HTTP server listen address for metrics and health (empty to disable)
+	LogLevel        string // the log level (debug, info, warn, error)
+	LogFormat       string // the log format (json or text), only used at startup
+	TraceExporter   string // the trace exporter (none, otlp or stdout)
+	JobStore        string // the job history store (e.g. sqlite:///data/jobs.db, empty to disable)
+	StuckThreshold  int    // how long a job can run before the worker is considered stuck (in seconds)
+	LocalVisibility int    // how long a received local queue message is hidden before it is redelivered (in seconds)
+	MinFreeSpace    int    // the minimum work directory free space to be ready and to admit jobs (in MB)
+	DiskWait        int    // how long a job waits for work directory space before it is deferred (in seconds)
 
 	// conversion configuration
-	ConvertBinary  string            // the conversion binary
-	ConvertSuffix  string            // the suffix of converyed files
-	DeleteSource   bool              // delete the bucket object after conversion
-	ConvertOptions map[string]string // the conversion options per filetype
+	ConvertBinary  string             // the conversion binary
+	ConvertSuffix  string             // the suffix of converyed files
+	ConvertVersion string             // the converter option used to check it is runnable
+	ConvertStdin   string             // the converter input argument to read from stdin (empty to convert a downloaded file)
+	ConvertStdout  string             // the converter output argument to write to stdout (empty to upload a converted file)
+	DeleteSource   bool               // delete the bucket object after conversion
+	ConvertOptions map[string]string  // the conversion options per filetype
+	DiskFactors    map[string]float64 // the work directory space needed per source byte per filetype
+
+	// source object disposition
+	SourceDisposition     string      // what happens to processed source objects (none, delete, archive or tag)
+	ArchiveDestination    Destination // where processed source objects are archived
+	ArchiveStorageClass   string      // the storage class of archived objects (empty for the bucket default)
+	ProcessedTag          string      // the tag applied to processed source objects (key=value)
+	QuarantineDestination Destination // where source objects that cannot be converted are moved (unset to leave them)
+	AuditLog              string      // the audit log of source deletions (s3://bucket[/prefix], a filename or stderr)
 
 	// output/naming configuration
-	OutputFSRoot       string   // the output root directory
-	OutputBucket       string   // the output bucket
-	InputNameRegex     []string // the list of possible input name regular expressions
-	OutputNameTemplate []string // the list of corresponding output name templates
+	OutputFSRoot       string            // the output root directory
+	OutputBucket       string            // the output bucket
+	Outputs            string            // whether an output root and an output bucket are both written (single or both)
+	OutputKeyPrefix    string            // the prefix prepended to output names
+	OutputTargets      []OutputTarget    // additional destinations every output is written to
+	OutputMD5          bool              // compute the MD5 of outputs as well as the SHA-256 (verified by S3 for single part uploads only)
+	OutputDirMode      os.FileMode       // the mode of created filesystem output directories (0 for 0755 less the umask)
+	OutputFileMode     os.FileMode       // the mode of filesystem output files (0 for the umask default)
+	OutputGroup        string            // the group (name or id) of filesystem outputs (empty to leave unchanged)
+	OutputShardDepth   int               // the number of hashed directory levels below filesystem roots (0 for none)
+	OutputShardWidth   int               // the number of hex digits in each hashed directory name
+	OutputSidecar      bool              // write a JSON sidecar with the checksums alongside filesystem outputs
+	CompletionEvents   bool              // emit an event when a job completes as well as when it fails
+	OutputContentTypes map[string]string // the content types by output suffix, beyond the defaults
+	OutputMetadata     map[string]string // the user metadata templates by name
+	OutputTags         map[string]string // the object tag templates by key
+	OutputCacheControl string            // the Cache-Control header for outputs (empty for none)
+	OutputStorageClass string            // the storage class of outputs (empty for the bucket default)
+	Routes             []OutputRoute     // the ordered list of per source bucket/prefix output routes
+	Rules              []RoutingRule     // the ordered list of routing rules
+
+	ConfigFile         string // the optional configuration file
+	ConfigPollInterval int    // how often to check the configuration file for changes (in seconds, 0 to disable)
 }
 
-func envWithDefault(env string, defaultValue string) string {
+// configLoader loads the configuration from the environment and the optional configuration file,
+// accumulating any problems so they can all be reported
+type configLoader struct {
+	settings map[string]string // scalar values from the configuration file, keyed by environment variable name
+	errs     []error           // the problems found
+}
+
+func (l *configLoader) fail(format string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// lookup a setting; environment variables override values from the configuration file
+func (l *configLoader) lookupSetting(env string) (string, bool) {
 	val, set := os.LookupEnv(env)
+	if set == true {
+		return val, true
+	}
+	val, set = l.settings[env]
+	return val, set
+}
+
+func (l *configLoader) envWithDefault(env string, defaultValue string) string {
+	val, set := l.lookupSetting(env)
 
 	if set == false {
-		log.Printf("[main] INFO: environment variable not set: [%s] using default value [%s]", env, defaultValue)
+		slog.Debug("environment variable not set, using default value", "variable", env, "default", defaultValue)
 		return defaultValue
 	}
 
 	return val
 }
 
-func ensureSet(env string) string {
-	val, set := os.LookupEnv(env)
+func (l *configLoader) ensureSet(env string) (string, bool) {
+	val, set := l.lookupSetting(env)
 
 	if set == false {
-		log.Printf("[main] ERROR: environment variable not set: [%s]", env)
-		os.Exit(1)
+		l.fail("environment variable not set: [%s]", env)
+		return "", false
 	}
 
-	return val
+	return val, true
 }
 
-func ensureSetAndNonEmpty(env string) string {
-	val := ensureSet(env)
+func (l *configLoader) ensureSetAndNonEmpty(env string) string {
+	val, set := l.ensureSet(env)
 
-	if val == "" {
-		log.Printf("[main] ERROR: environment variable not set: [%s]", env)
-		os.Exit(1)
+	if set == true && val == "" {
+		l.fail("environment variable not set: [%s]", env)
 	}
 
 	return val
 }
 
-func envToInt(env string) int {
+func (l *configLoader) envToInt(env string) int {
+
+	number := l.ensureSetAndNonEmpty(env)
+	if number == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		l.fail("incorrectly formatted '%s' value (%s)", env, number)
+	}
+	return n
+}
+
+func (l *configLoader) envToIntWithDefault(env string, defaultValue int) int {
 
-	number := ensureSetAndNonEmpty(env)
+	number := l.envWithDefault(env, strconv.Itoa(defaultValue))
 	n, err := strconv.Atoi(number)
-	fatalIfError(err)
+	if err != nil {
+		l.fail("incorrectly formatted '%s' value (%s)", env, number)
+	}
 	return n
 }
 
-func envToBoolean(env string) bool {
+func (l *configLoader) envToBoolean(env string) bool {
+
+	value := l.ensureSetAndNonEmpty(env)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail("incorrectly formatted '%s' value (%s)", env, value)
+	}
+	return b
+}
+
+// add the key=value settings of a numbered environment variable sequence to a map, the sequence ends at the
+// first variable that is not set
+func (l *configLoader) envToMap(format string, max int, values map[string]string) {
+	for ix := 0; ix < max; ix++ {
+		env := fmt.Sprintf(format, ix+1)
+		val, set := os.LookupEnv(env)
+		if set == false {
+			break
+		}
+		s := strings.SplitN(val, "=", 2)
+		if len(s) != 2 {
+			l.fail("incorrectly formatted '%s' value (%s)", env, val)
+			continue
+		}
+		values[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
+	}
+}
+
+// an optional octal file mode such as 0750, 0 if not set
+func (l *configLoader) envToFileMode(env string) os.FileMode {
+
+	val := l.envWithDefault(env, "")
+	if len(val) == 0 {
+		return 0
+	}
+	// the permissions plus the setgid and sticky bits, setuid makes no sense for outputs
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode == 0 || mode > 07777 || mode&04000 != 0 {
+		l.fail("incorrectly formatted '%s' value (%s), expected an octal mode such as 0750 or 02775", env, val)
+		return 0
+	}
+	return unixToFileMode(uint32(mode))
+}
+
+// an optional destination that must be of the form s3://bucket[/prefix]
+func (l *configLoader) envToBucketDestination(env string) Destination {
+
+	val := l.envWithDefault(env, "")
+	if len(val) == 0 {
+		return Destination{}
+	}
+	dest, err := parseDestination(val)
+	if err == nil && len(dest.Bucket) == 0 {
+		err = fmt.Errorf("destination '%s' must be s3://bucket[/prefix]", val)
+	}
+	if err != nil {
+		l.fail("%s (%s)", err.Error(), env)
+	}
+	return dest
+}
+
+func (l *configLoader) envToBooleanWithDefault(env string, defaultValue bool) bool {
 
-	value := ensureSetAndNonEmpty(env)
+	value := l.envWithDefault(env, strconv.FormatBool(defaultValue))
 	b, err := strconv.ParseBool(value)
-	fatalIfError(err)
+	if err != nil {
+		l.fail("incorrectly formatted '%s' value (%s)", env, value)
+	}
 	return b
 }
 
+// a local backend must be an absolute path
+func (l *configLoader) checkLocalSpec(env string, spec string) {
+	if validLocalSpec(spec) == false {
+		l.fail("local backend '%s' must be file:// followed by an absolute path (%s)", spec, env)
+	}
+}
+
 // LoadConfiguration will load the service configuration from env/cmdline
 // and return a pointer to it. Any failures are fatal.
 func LoadConfiguration() *ServiceConfig {
 
+	cfg, errs := loadConfiguration()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			slog.Error("configuration error", "error", err)
+		}
+		os.Exit(1)
+	}
+
+	logConfiguration(*cfg)
+	return cfg
+}
+
+// load and validate the service configuration, returning all the problems found
+// create a loader for the environment and the optional configuration file (IIIF_INGEST_CONFIG_FILE), the
+// file is returned as it also provides the non scalar values
+func newConfigLoader() (*configLoader, *configFile, error) {
+
+	l := &configLoader{}
+	filename := l.envWithDefault("IIIF_INGEST_CONFIG_FILE", "")
+	if len(filename) == 0 {
+		return l, nil, nil
+	}
+	cf, err := loadConfigFile(filename)
+	if err != nil {
+		return l, nil, err
+	}
+	l.settings = cf.settings()
+	return l, cf, nil
+}
+
+func loadConfiguration() (*ServiceConfig, []error) {
+
 	var cfg ServiceConfig
 
+	// the optional configuration file
+	l, cf, err := newConfigLoader()
+	if err != nil {
+		l.fail("loading configuration file (%s)", err.Error())
+		return nil, l.errs
+	}
+	cfg.ConfigFile = l.envWithDefault("IIIF_INGEST_CONFIG_FILE", "")
+
+	cfg.ConfigPollInterval = l.envToIntWithDefault("IIIF_INGEST_CONFIG_POLL_INTERVAL", 30)
+
 	// service configuration
-	cfg.InQueueName = ensureSetAndNonEmpty("IIIF_INGEST_IN_QUEUE")
-	cfg.PollTimeOut = int64(envToInt("IIIF_INGEST_QUEUE_POLL_TIMEOUT"))
-	cfg.LocalWorkDir = ensureSetAndNonEmpty("IIIF_INGEST_WORK_DIR")
-	cfg.WorkerQueueSize = envToInt("IIIF_INGEST_WORK_QUEUE_SIZE")
-	cfg.Workers = envToInt("IIIF_INGEST_WORKERS")
+	cfg.InQueueName = l.ensureSetAndNonEmpty("IIIF_INGEST_IN_QUEUE")
+	cfg.EventQueueName = l.envWithDefault("IIIF_INGEST_EVENT_QUEUE", "")
+	cfg.ObjectStore = l.envWithDefault("IIIF_INGEST_OBJECT_STORE", "s3")
+	if cfg.ObjectStore != "s3" && strings.HasPrefix(cfg.ObjectStore, localScheme) == false {
+		l.fail("unsupported object store '%s' (IIIF_INGEST_OBJECT_STORE)", cfg.ObjectStore)
+	}
+	cfg.S3Endpoint = l.envWithDefault("IIIF_INGEST_S3_ENDPOINT", "")
+	if len(cfg.S3Endpoint) != 0 {
+		u, err := url.Parse(cfg.S3Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			l.fail("S3 endpoint '%s' must be an http or https URL (IIIF_INGEST_S3_ENDPOINT)", cfg.S3Endpoint)
+		}
+	}
+	cfg.S3Region = l.envWithDefault("IIIF_INGEST_S3_REGION", "")
+	cfg.S3PathStyle = l.envToBooleanWithDefault("IIIF_INGEST_S3_PATH_STYLE", false)
+	cfg.S3AccessKey = l.envWithDefault("IIIF_INGEST_S3_ACCESS_KEY", "")
+	cfg.S3SecretKey = l.envWithDefault("IIIF_INGEST_S3_SECRET_KEY", "")
+	cfg.S3PartSize = l.envToIntWithDefault("IIIF_INGEST_S3_PART_SIZE", 16)
+	if cfg.S3PartSize < 5 {
+		l.fail("S3 part size must be at least 5 MB (IIIF_INGEST_S3_PART_SIZE)")
+	}
+	cfg.S3Concurrency = l.envToIntWithDefault("IIIF_INGEST_S3_CONCURRENCY", 5)
+	if cfg.S3Concurrency < 1 {
+		l.fail("S3 concurrency must be at least 1 (IIIF_INGEST_S3_CONCURRENCY)")
+	}
+	cfg.S3MaxRetries = l.envToIntWithDefault("IIIF_INGEST_S3_MAX_RETRIES", 5)
+	if cfg.S3MaxRetries < 0 {
+		l.fail("S3 retries cannot be negative (IIIF_INGEST_S3_MAX_RETRIES)")
+	}
+	if (len(cfg.S3AccessKey) == 0) != (len(cfg.S3SecretKey) == 0) {
+		l.fail("IIIF_INGEST_S3_ACCESS_KEY and IIIF_INGEST_S3_SECRET_KEY must be specified together")
+	}
+	l.checkLocalSpec("IIIF_INGEST_IN_QUEUE", cfg.InQueueName)
+	l.checkLocalSpec("IIIF_INGEST_EVENT_QUEUE", cfg.EventQueueName)
+	l.checkLocalSpec("IIIF_INGEST_OBJECT_STORE", cfg.ObjectStore)
+	cfg.PollTimeOut = int64(l.envToInt("IIIF_INGEST_QUEUE_POLL_TIMEOUT"))
+	cfg.LocalWorkDir = l.ensureSetAndNonEmpty("IIIF_INGEST_WORK_DIR")
+	cfg.WorkerQueueSize = l.envToInt("IIIF_INGEST_WORK_QUEUE_SIZE")
+	cfg.ReceiveBatch = l.envToIntWithDefault("IIIF_INGEST_RECEIVE_BATCH", 1)
+	if cfg.ReceiveBatch < 1 || cfg.ReceiveBatch > 10 {
+		l.fail("receive batch must be between 1 and 10 (IIIF_INGEST_RECEIVE_BATCH)")
+	}
+	cfg.Workers = l.envToInt("IIIF_INGEST_WORKERS")
+
+	// adaptive workers, the worker count is the maximum
+	cfg.AdaptiveWorkers = l.envToBooleanWithDefault("IIIF_INGEST_ADAPTIVE_WORKERS", false)
+	cfg.MinWorkers = l.envToIntWithDefault("IIIF_INGEST_MIN_WORKERS", 1)
+	cfg.AdaptInterval = l.envToIntWithDefault("IIIF_INGEST_ADAPT_INTERVAL", 15)
+	cfg.CPUHighWater = l.envToIntWithDefault("IIIF_INGEST_CPU_HIGH", 90)
+	cfg.MemoryHighWater = l.envToIntWithDefault("IIIF_INGEST_MEMORY_HIGH", 85)
+	if cfg.AdaptiveWorkers == true {
+		if cfg.MinWorkers < 1 || cfg.MinWorkers > cfg.Workers {
+			l.fail("minimum workers must be between 1 and %d (IIIF_INGEST_MIN_WORKERS)", cfg.Workers)
+		}
+		if cfg.AdaptInterval < 1 {
+			l.fail("adapt interval must be at least 1 second (IIIF_INGEST_ADAPT_INTERVAL)")
+		}
+		if cfg.CPUHighWater < 1 || cfg.CPUHighWater > 100 || cfg.MemoryHighWater < 1 || cfg.MemoryHighWater > 100 {
+			l.fail("high water marks must be percentages (IIIF_INGEST_CPU_HIGH, IIIF_INGEST_MEMORY_HIGH)")
+		}
+	}
+
+	// lanes from the configuration file are replaced by any in the environment
+	if cf != nil {
+		cfg.Lanes = cf.workerLanes()
+	}
+	for ix := 0; ix < maxLanes; ix++ {
+		env := fmt.Sprintf("IIIF_INGEST_LANE_%02d", ix+1)
+		val, set := os.LookupEnv(env)
+		if set == false {
+			break
+		}
+		if ix == 0 {
+			cfg.Lanes = nil
+		}
+		lane, err := parseLane(val)
+		if err != nil {
+			l.fail("incorrectly formatted '%s' value (%s): %s", env, val, err.Error())
+			continue
+		}
+		cfg.Lanes = append(cfg.Lanes, lane)
+	}
+	if len(cfg.Lanes) != 0 {
+		err := validateLanes(cfg.Lanes, cfg.Workers)
+		if err != nil {
+			l.fail("%s (IIIF_INGEST_LANE_nn)", err.Error())
+		}
+	}
+
+	cfg.HttpListen = l.envWithDefault("IIIF_INGEST_HTTP_LISTEN", ":8080")
+	cfg.JobStore = l.envWithDefault("IIIF_INGEST_JOB_STORE", "")
+	cfg.TraceExporter = l.envWithDefault("IIIF_INGEST_TRACE_EXPORTER", "none")
+	switch cfg.TraceExporter {
+	case "none", "otlp", "stdout":
+	default:
+		l.fail("unsupported trace exporter '%s' (IIIF_INGEST_TRACE_EXPORTER)", cfg.TraceExporter)
+	}
+	cfg.LogLevel = l.envWithDefault("IIIF_INGEST_LOG_LEVEL", "info")
+	_, err = parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		l.fail("%s (IIIF_INGEST_LOG_LEVEL)", err.Error())
+	}
+	cfg.LogFormat = strings.ToLower(l.envWithDefault("IIIF_INGEST_LOG_FORMAT", "json"))
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		l.fail("unsupported log format '%s', expected json or text (IIIF_INGEST_LOG_FORMAT)", cfg.LogFormat)
+	}
+	cfg.StuckThreshold = l.envToIntWithDefault("IIIF_INGEST_STUCK_THRESHOLD", 3600)
+	// a local queue message must stay hidden for as long as a job can run or it is processed twice
+	cfg.LocalVisibility = l.envToIntWithDefault("IIIF_INGEST_LOCAL_VISIBILITY_TIMEOUT", cfg.StuckThreshold)
+	if cfg.LocalVisibility < 1 {
+		l.fail("local visibility timeout must be at least 1 second (IIIF_INGEST_LOCAL_VISIBILITY_TIMEOUT)")
+	}
+	cfg.MinFreeSpace = l.envToIntWithDefault("IIIF_INGEST_MIN_FREE_SPACE", 1024)
+	cfg.DiskWait = l.envToIntWithDefault("IIIF_INGEST_DISK_WAIT", 300)
 
 	// conversion configuration
-	cfg.ConvertBinary = ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_BIN")
-	cfg.ConvertSuffix = ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_SUFFIX")
-	cfg.DeleteSource = envToBoolean("IIIF_INGEST_DELETE_SOURCE")
+	cfg.ConvertBinary = l.ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_BIN")
+	cfg.ConvertSuffix = l.ensureSetAndNonEmpty("IIIF_INGEST_CONVERT_SUFFIX")
+	cfg.ConvertVersion = l.envWithDefault("IIIF_INGEST_CONVERT_VERSION_OPT", "-version")
+	cfg.ConvertStdin = l.envWithDefault("IIIF_INGEST_CONVERT_STDIN_ARG", "")
+	cfg.ConvertStdout = l.envWithDefault("IIIF_INGEST_CONVERT_STDOUT_ARG", "")
+	cfg.DeleteSource = l.envToBoolean("IIIF_INGEST_DELETE_SOURCE")
+
+	// source object disposition, IIIF_INGEST_DELETE_SOURCE selects the default
+	defaultDisposition := dispositionNone
+	if cfg.DeleteSource == true {
+		defaultDisposition = dispositionDelete
+	}
+	cfg.SourceDisposition = l.envWithDefault("IIIF_INGEST_SOURCE_DISPOSITION", defaultDisposition)
+	switch cfg.SourceDisposition {
+	case dispositionNone, dispositionDelete, dispositionArchive, dispositionTag:
+		if cfg.DeleteSource == true && cfg.SourceDisposition != dispositionDelete {
+			l.fail("IIIF_INGEST_DELETE_SOURCE conflicts with source disposition '%s' (IIIF_INGEST_SOURCE_DISPOSITION)", cfg.SourceDisposition)
+		}
+	default:
+		l.fail("unsupported source disposition '%s' (IIIF_INGEST_SOURCE_DISPOSITION)", cfg.SourceDisposition)
+	}
+	cfg.ArchiveDestination = l.envToBucketDestination("IIIF_INGEST_ARCHIVE_DEST")
+	if cfg.SourceDisposition == dispositionArchive && cfg.ArchiveDestination.isSet() == false {
+		l.fail("an archive destination (IIIF_INGEST_ARCHIVE_DEST) is required to archive source objects")
+	}
+	cfg.ArchiveStorageClass = l.envWithDefault("IIIF_INGEST_ARCHIVE_STORAGE_CLASS", "")
+	if len(cfg.ArchiveStorageClass) != 0 && validStorageClass(cfg.ArchiveStorageClass) == false {
+		l.fail("unsupported storage class '%s' (IIIF_INGEST_ARCHIVE_STORAGE_CLASS)", cfg.ArchiveStorageClass)
+	}
+	cfg.ProcessedTag = l.envWithDefault("IIIF_INGEST_PROCESSED_TAG", defaultProcessedTag)
+	_, _, err = parseTag(cfg.ProcessedTag)
+	if err != nil {
+		l.fail("%s (IIIF_INGEST_PROCESSED_TAG)", err.Error())
+	}
+	cfg.QuarantineDestination = l.envToBucketDestination("IIIF_INGEST_QUARANTINE_DEST")
+
+	// removals are always audited, to standard error unless somewhere more durable is configured
+	cfg.AuditLog = l.envWithDefault("IIIF_INGEST_AUDIT_LOG", "")
+	if len(cfg.AuditLog) == 0 && cfg.auditRequired() == true {
+		cfg.AuditLog = auditLogStderr
+	}
+	if len(cfg.AuditLog) != 0 && cfg.AuditLog != auditLogStderr {
+		_, err := parseDestination(cfg.AuditLog)
+		if err != nil {
+			l.fail("%s (IIIF_INGEST_AUDIT_LOG)", err.Error())
+		}
+	}
+
+	// ensure the conversion binary is available
+	if len(cfg.ConvertBinary) != 0 {
+		_, err := exec.LookPath(cfg.ConvertBinary)
+		if err != nil {
+			l.fail("conversion binary is not executable (%s)", err.Error())
+		}
+	}
 
 	cfg.ConvertOptions = make(map[string]string)
+	if cf != nil {
+		for k, v := range cf.ConvertOptions {
+			cfg.ConvertOptions[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
 	for ix := 0; ix < maxConvertOptions; ix++ {
 		env := fmt.Sprintf("IIIF_INGEST_CONVERT_OPTS_%02d", ix+1)
 		val, set := os.LookupEnv(env)
@@ -111,17 +493,199 @@ func LoadConfiguration() *ServiceConfig {
 			if len(s) == 2 {
 				cfg.ConvertOptions[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
 			} else {
-				log.Printf("[main] ERROR: incorrectly formatted '%s' value (%s)", env, val)
-				os.Exit(1)
+				l.fail("incorrectly formatted '%s' value (%s)", env, val)
+			}
+		} else {
+			break
+		}
+	}
+
+	// the work directory space estimates, beyond the download itself
+	cfg.DiskFactors = map[string]float64{"*": defaultDiskFactor}
+	if cf != nil {
+		for k, v := range cf.DiskFactors {
+			cfg.DiskFactors[strings.TrimSpace(k)] = v
+		}
+	}
+	for ix := 0; ix < maxDiskFactors; ix++ {
+		env := fmt.Sprintf("IIIF_INGEST_DISK_FACTOR_%02d", ix+1)
+		val, set := os.LookupEnv(env)
+		if set == true {
+			s := strings.SplitN(val, "=", 2)
+			if len(s) != 2 {
+				l.fail("incorrectly formatted '%s' value (%s)", env, val)
+				continue
 			}
+			factor, err := strconv.ParseFloat(strings.TrimSpace(s[1]), 64)
+			if err != nil {
+				l.fail("incorrectly formatted '%s' value (%s)", env, val)
+				continue
+			}
+			cfg.DiskFactors[strings.TrimSpace(s[0])] = factor
 		} else {
 			break
 		}
 	}
+	factorTypes := make([]string, 0, len(cfg.DiskFactors))
+	for k := range cfg.DiskFactors {
+		factorTypes = append(factorTypes, k)
+	}
+	sort.Strings(factorTypes)
+	for _, k := range factorTypes {
+		if cfg.DiskFactors[k] < 0 {
+			l.fail("disk factor for '%s' cannot be negative (IIIF_INGEST_DISK_FACTOR_nn)", k)
+		}
+	}
 
 	// output configuration
-	cfg.OutputFSRoot = envWithDefault("IIIF_INGEST_OUTPUT_FS_ROOT", "")
-	cfg.OutputBucket = envWithDefault("IIIF_INGEST_OUTPUT_BUCKET", "")
+	cfg.OutputFSRoot = l.envWithDefault("IIIF_INGEST_OUTPUT_FS_ROOT", "")
+	cfg.OutputBucket = l.envWithDefault("IIIF_INGEST_OUTPUT_BUCKET", "")
+	cfg.OutputKeyPrefix = strings.Trim(l.envWithDefault("IIIF_INGEST_OUTPUT_PREFIX", ""), "/")
+
+	// the additional output targets; writing both an output root and an output bucket must be asked for, the
+	// root is then the default destination and the bucket is a required target
+	cfg.Outputs = l.envWithDefault("IIIF_INGEST_OUTPUTS", outputsSingle)
+	switch cfg.Outputs {
+	case outputsSingle:
+	case outputsBoth:
+		if len(cfg.OutputFSRoot) == 0 || len(cfg.OutputBucket) == 0 {
+			l.fail("writing both outputs requires an output root and an output bucket (IIIF_INGEST_OUTPUTS)")
+			break
+		}
+		cfg.OutputTargets = append(cfg.OutputTargets, OutputTarget{
+			Destination: Destination{Bucket: cfg.OutputBucket, KeyPrefix: cfg.OutputKeyPrefix},
+			Policy:      policyRequired,
+		})
+	default:
+		l.fail("unsupported outputs '%s', expected %s or %s (IIIF_INGEST_OUTPUTS)", cfg.Outputs, outputsSingle, outputsBoth)
+	}
+	if cf != nil {
+		cfg.OutputTargets = append(cfg.OutputTargets, cf.outputTargets()...)
+	}
+	for ix := 0; ix < maxOutputTargets; ix++ {
+		env := fmt.Sprintf("IIIF_INGEST_OUTPUT_TARGET_%02d", ix+1)
+		val, set := os.LookupEnv(env)
+		if set == false {
+			break
+		}
+		target, err := parseOutputTarget(val)
+		if err != nil {
+			l.fail("incorrectly formatted '%s' value (%s): %s", env, val, err.Error())
+			continue
+		}
+		cfg.OutputTargets = append(cfg.OutputTargets, target)
+	}
+	for _, t := range cfg.OutputTargets {
+		err := t.Destination.validate()
+		if err == nil && validPolicy(t.Policy) == false {
+			err = fmt.Errorf("unsupported policy '%s'", t.Policy)
+		}
+		if err != nil {
+			l.fail("%s for output target '%s'", err.Error(), t.Destination)
+		}
+	}
+
+	// filesystem output ownership, permissions and sharding
+	cfg.OutputDirMode = l.envToFileMode("IIIF_INGEST_OUTPUT_DIR_MODE")
+	cfg.OutputFileMode = l.envToFileMode("IIIF_INGEST_OUTPUT_FILE_MODE")
+	cfg.OutputGroup = l.envWithDefault("IIIF_INGEST_OUTPUT_GROUP", "")
+	if len(cfg.OutputGroup) != 0 {
+		_, err := lookupGroup(cfg.OutputGroup)
+		if err != nil {
+			l.fail("unknown group '%s' (IIIF_INGEST_OUTPUT_GROUP)", cfg.OutputGroup)
+		}
+	}
+	cfg.OutputShardDepth = l.envToIntWithDefault("IIIF_INGEST_OUTPUT_SHARD_DEPTH", 0)
+	if cfg.OutputShardDepth < 0 || cfg.OutputShardDepth > maxShardDepth {
+		l.fail("output shard depth must be between 0 and %d (IIIF_INGEST_OUTPUT_SHARD_DEPTH)", maxShardDepth)
+	}
+	cfg.OutputShardWidth = l.envToIntWithDefault("IIIF_INGEST_OUTPUT_SHARD_WIDTH", 2)
+	if cfg.OutputShardWidth < 1 || cfg.OutputShardWidth > maxShardWidth {
+		l.fail("output shard width must be between 1 and %d (IIIF_INGEST_OUTPUT_SHARD_WIDTH)", maxShardWidth)
+	}
+
+	cfg.OutputMD5 = l.envToBooleanWithDefault("IIIF_INGEST_OUTPUT_MD5", false)
+	cfg.OutputSidecar = l.envToBooleanWithDefault("IIIF_INGEST_OUTPUT_SIDECAR", false)
+	cfg.CompletionEvents = l.envToBooleanWithDefault("IIIF_INGEST_COMPLETION_EVENTS", false)
+	cfg.OutputCacheControl = l.envWithDefault("IIIF_INGEST_OUTPUT_CACHE_CONTROL", "")
+	cfg.OutputStorageClass = l.envWithDefault("IIIF_INGEST_OUTPUT_STORAGE_CLASS", "")
+	if len(cfg.OutputStorageClass) != 0 && validStorageClass(cfg.OutputStorageClass) == false {
+		l.fail("unsupported storage class '%s' (IIIF_INGEST_OUTPUT_STORAGE_CLASS)", cfg.OutputStorageClass)
+	}
+
+	// the output object content types, metadata and tags; environment values are added to (or replace)
+	// those from the configuration file
+	cfg.OutputContentTypes = make(map[string]string)
+	cfg.OutputMetadata = make(map[string]string)
+	cfg.OutputTags = make(map[string]string)
+	if cf != nil {
+		for k, v := range cf.ContentTypes {
+			cfg.OutputContentTypes[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		for k, v := range cf.OutputMetadata {
+			cfg.OutputMetadata[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		for k, v := range cf.OutputTags {
+			cfg.OutputTags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	l.envToMap("IIIF_INGEST_CONTENT_TYPE_%02d", maxContentTypes, cfg.OutputContentTypes)
+	l.envToMap("IIIF_INGEST_OUTPUT_METADATA_%02d", maxOutputMetadata, cfg.OutputMetadata)
+	l.envToMap("IIIF_INGEST_OUTPUT_TAG_%02d", maxOutputTags, cfg.OutputTags)
+	for _, suffix := range sortedKeys(cfg.OutputContentTypes) {
+		_, _, err := mime.ParseMediaType(cfg.OutputContentTypes[suffix])
+		if err != nil {
+			l.fail("invalid content type '%s' for '%s' (IIIF_INGEST_CONTENT_TYPE_nn)", cfg.OutputContentTypes[suffix], suffix)
+		}
+	}
+	for _, name := range sortedKeys(cfg.OutputMetadata) {
+		if metadataNameRegex.MatchString(name) == false {
+			l.fail("invalid output metadata name '%s' (IIIF_INGEST_OUTPUT_METADATA_nn)", name)
+		} else if strings.EqualFold(name, metadataSHA256) == true || strings.EqualFold(name, metadataMD5) == true {
+			l.fail("output metadata name '%s' is reserved for the output checksum (IIIF_INGEST_OUTPUT_METADATA_nn)", name)
+		}
+		err := validateOutputTemplate(cfg.OutputMetadata[name])
+		if err != nil {
+			l.fail("%s (IIIF_INGEST_OUTPUT_METADATA_nn)", err.Error())
+		}
+	}
+	if len(cfg.OutputTags) > maxOutputTags {
+		l.fail("at most %d output tags are allowed (IIIF_INGEST_OUTPUT_TAG_nn)", maxOutputTags)
+	}
+	for _, key := range sortedKeys(cfg.OutputTags) {
+		if len(key) == 0 || len(key) > 128 || len(cfg.OutputTags[key]) > 256 {
+			l.fail("output tag '%s' must have a key of 1 to 128 characters and a value of at most 256 (IIIF_INGEST_OUTPUT_TAG_nn)", key)
+		}
+		err := validateOutputTemplate(cfg.OutputTags[key])
+		if err != nil {
+			l.fail("%s (IIIF_INGEST_OUTPUT_TAG_nn)", err.Error())
+		}
+	}
+
+	// output routes from the configuration file are evaluated first
+	if cf != nil {
+		cfg.Routes = cf.outputRoutes()
+	}
+
+	for ix := 0; ix < maxOutputRoutes; ix++ {
+		env := fmt.Sprintf("IIIF_INGEST_ROUTE_%02d", ix+1)
+		val, set := os.LookupEnv(env)
+		if set == true {
+			route, err := parseOutputRoute(val)
+			if err != nil {
+				l.fail("incorrectly formatted '%s' value (%s): %s", env, val, err.Error())
+				continue
+			}
+			cfg.Routes = append(cfg.Routes, route)
+		} else {
+			break
+		}
+	}
+
+	// routing rules from the configuration file are evaluated first
+	if cf != nil {
+		cfg.Rules = cf.routingRules()
+	}
 
 	for ix := 0; ix < maxNameRegex; ix++ {
 		env := fmt.Sprintf("IIIF_INGEST_NAME_MAP_%02d", ix+1)
@@ -129,74 +693,250 @@ func LoadConfiguration() *ServiceConfig {
 		if set == true {
 			s := strings.SplitN(val, "=", 2)
 			if len(s) == 2 {
-				// ensure the regex compiles
-				_, err := regexp.Compile(strings.TrimSpace(s[0]))
-				if err != nil {
-					log.Printf("[main] ERROR: incorrectly formatted '%s' value (%s)", env, val)
-					os.Exit(1)
-				}
-				cfg.InputNameRegex = append(cfg.InputNameRegex, strings.TrimSpace(s[0]))
-				cfg.OutputNameTemplate = append(cfg.OutputNameTemplate, strings.TrimSpace(s[1]))
+				cfg.Rules = append(cfg.Rules, RoutingRule{
+					Name:               fmt.Sprintf("name map %02d", ix+1),
+					InputNameRegex:     strings.TrimSpace(s[0]),
+					OutputNameTemplate: strings.TrimSpace(s[1]),
+				})
 			} else {
-				log.Printf("[main] ERROR: incorrectly formatted '%s' value (%s)", env, val)
-				os.Exit(1)
+				l.fail("incorrectly formatted '%s' value (%s)", env, val)
 			}
 		} else {
 			break
 		}
 	}
 
-	// service configuration
-	log.Printf("[config] InQueueName          = [%s]", cfg.InQueueName)
-	log.Printf("[config] PollTimeOut          = [%d]", cfg.PollTimeOut)
-	log.Printf("[config] LocalWorkDir         = [%s]", cfg.LocalWorkDir)
-	log.Printf("[config] WorkerQueueSize      = [%d]", cfg.WorkerQueueSize)
-	log.Printf("[config] Workers              = [%d]", cfg.Workers)
+	// complete the routing rules, any selections not made by the rule use the service defaults
+	for ix := range cfg.Rules {
+		r := &cfg.Rules[ix]
+		if len(r.InputNameRegex) == 0 || len(r.OutputNameTemplate) == 0 {
+			l.fail("routing rule '%s' must specify a regex and a name template", r.Name)
+		}
+		// ensure the regex compiles
+		err := r.compile()
+		if err != nil {
+			l.fail("routing rule '%s' has an invalid regex (%s)", r.Name, err.Error())
+		} else {
+			err = r.validateTemplate()
+			if err != nil {
+				l.fail("routing rule '%s' has an invalid name template (%s)", r.Name, err.Error())
+			}
+		}
+		if len(r.ConvertSuffix) == 0 {
+			r.ConvertSuffix = cfg.ConvertSuffix
+		}
+		if len(r.ConvertOptions) != 0 && convertParams(r.ConvertOptions) > maxConvertParams {
+			l.fail("routing rule '%s' has %d conversion options, at most %d are supported", r.Name, convertParams(r.ConvertOptions), maxConvertParams)
+		}
+	}
 
-	// conversion configuration
-	log.Printf("[config] ConvertBinary        = [%s]", cfg.ConvertBinary)
-	log.Printf("[config] ConvertSuffix        = [%s]", cfg.ConvertSuffix)
-	log.Printf("[config] DeleteSource         = [%t]", cfg.DeleteSource)
+	if len(cfg.ConvertOptions) == 0 {
+		l.fail("must specify conversion option(s) (IIIF_INGEST_CONVERT_OPTS_nn)")
+	} else {
+		// ensure we have the default value
+		_, haveDefault := cfg.ConvertOptions["*"]
+		if haveDefault == false {
+			l.fail("must specify default conversion option(s) (IIIF_INGEST_CONVERT_OPTS_nn)")
+		}
+		for k, v := range cfg.ConvertOptions {
+			if convertParams(v) > maxConvertParams {
+				l.fail("conversion options for '%s' have %d options, at most %d are supported (IIIF_INGEST_CONVERT_OPTS_nn)", k, convertParams(v), maxConvertParams)
+			}
+		}
+	}
 
-	for k, v := range cfg.ConvertOptions {
-		log.Printf("[config] Convert options map  = [%s ==> %s]", k, v)
+	if len(cfg.Rules) == 0 {
+		l.fail("must specify name map value(s) (IIIF_INGEST_NAME_MAP_nn) or routing rules")
 	}
 
-	// output configuration
-	log.Printf("[config] OutputFSRoot         = [%s]", cfg.OutputFSRoot)
-	log.Printf("[config] OutputBucket         = [%s]", cfg.OutputBucket)
+	// validate output target values
+	def := cfg.defaultDestination()
+	if def.isSet() == true {
+		err := def.validate()
+		if err != nil {
+			l.fail("%s (IIIF_INGEST_OUTPUT_FS_ROOT or IIIF_INGEST_OUTPUT_BUCKET, set IIIF_INGEST_OUTPUTS=both to write both)", err.Error())
+		}
+	}
 
-	for ix, _ := range cfg.InputNameRegex {
-		log.Printf("[config] Input name map %02d    = [%s ==> %s]", ix+1, cfg.InputNameRegex[ix], cfg.OutputNameTemplate[ix])
+	for _, r := range cfg.Routes {
+		err := r.Destination.validate()
+		if err != nil {
+			l.fail("%s for output route '%s/%s'", err.Error(), r.SourceBucket, r.KeyPrefix)
+		}
 	}
 
-	if len(cfg.ConvertOptions) == 0 {
-		log.Printf("[main] ERROR: must specify conversion option(s) (IIIF_INGEST_CONVERT_OPTS_nn)")
-		os.Exit(1)
+	// each rule must have a destination of its own or be able to fall back to one
+	for _, r := range cfg.Rules {
+		if r.Destination.isSet() == true {
+			err := r.Destination.validate()
+			if err != nil {
+				l.fail("%s for rule '%s'", err.Error(), r.Name)
+			}
+		} else if def.isSet() == false && len(cfg.Routes) == 0 {
+			l.fail("must specify output root (IIIF_INGEST_OUTPUT_FS_ROOT), output bucket (IIIF_INGEST_OUTPUT_BUCKET) or output routes (IIIF_INGEST_ROUTE_nn) for rule '%s'", r.Name)
+		}
 	}
 
-	// ensure we have the default value
-	_, haveDefault := cfg.ConvertOptions["*"]
-	if haveDefault == false {
-		log.Printf("[main] ERROR: must specify default conversion option(s) (IIIF_INGEST_CONVERT_OPTS_nn)")
-		os.Exit(1)
+	return &cfg, l.errs
+}
+
+// log the configuration
+func logConfiguration(cfg ServiceConfig) {
+	for _, line := range cfg.describe() {
+		slog.Info("configuration", "setting", line)
 	}
+}
 
-	if len(cfg.InputNameRegex) == 0 {
-		log.Printf("[main] ERROR: must specify name map value(s) (IIIF_INGEST_NAME_MAP_nn)")
-		os.Exit(1)
+// describe the configuration, one line per setting
+func (cfg ServiceConfig) describe() []string {
+
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
 	}
 
-	// validate output target values
-	if len(cfg.OutputFSRoot) == 0 && len(cfg.OutputBucket) == 0 {
-		log.Printf("[main] ERROR: must specify output root (IIIF_INGEST_OUTPUT_ROOT) or output bucket (IIIF_INGEST_OUTPUT_BUCKET)")
-		os.Exit(1)
+	// service configuration
+	add("ConfigFile           = [%s]", cfg.ConfigFile)
+	add("ConfigPollInterval   = [%d]", cfg.ConfigPollInterval)
+	add("InQueueName          = [%s]", cfg.InQueueName)
+	add("EventQueueName       = [%s]", cfg.EventQueueName)
+	add("ObjectStore          = [%s]", cfg.ObjectStore)
+	add("S3Endpoint           = [%s]", cfg.S3Endpoint)
+	add("S3Region             = [%s]", cfg.S3Region)
+	add("S3PathStyle          = [%t]", cfg.S3PathStyle)
+	add("S3AccessKey          = [%s]", cfg.S3AccessKey)
+	add("S3SecretKey          = [%s]", redact(cfg.S3SecretKey))
+	add("S3PartSize           = [%d]", cfg.S3PartSize)
+	add("S3Concurrency        = [%d]", cfg.S3Concurrency)
+	add("S3MaxRetries         = [%d]", cfg.S3MaxRetries)
+	add("PollTimeOut          = [%d]", cfg.PollTimeOut)
+	add("LocalWorkDir         = [%s]", cfg.LocalWorkDir)
+	add("WorkerQueueSize      = [%d]", cfg.WorkerQueueSize)
+	add("ReceiveBatch         = [%d]", cfg.ReceiveBatch)
+	add("Workers              = [%d]", cfg.Workers)
+	for _, lane := range cfg.Lanes {
+		add("Lane                 = [%s]", lane)
 	}
+	add("AdaptiveWorkers      = [%t]", cfg.AdaptiveWorkers)
+	add("MinWorkers           = [%d]", cfg.MinWorkers)
+	add("AdaptInterval        = [%d]", cfg.AdaptInterval)
+	add("CPUHighWater         = [%d]", cfg.CPUHighWater)
+	add("MemoryHighWater      = [%d]", cfg.MemoryHighWater)
+	add("HttpListen           = [%s]", cfg.HttpListen)
+	add("LogLevel             = [%s]", cfg.LogLevel)
+	add("LogFormat            = [%s]", cfg.LogFormat)
+	add("TraceExporter        = [%s]", cfg.TraceExporter)
+	add("JobStore             = [%s]", cfg.JobStore)
+	add("StuckThreshold       = [%d]", cfg.StuckThreshold)
+	add("LocalVisibility      = [%d]", cfg.LocalVisibility)
+	add("MinFreeSpace         = [%d]", cfg.MinFreeSpace)
+	add("DiskWait             = [%d]", cfg.DiskWait)
 
-	if len(cfg.OutputFSRoot) != 0 && len(cfg.OutputBucket) != 0 {
-		log.Printf("[main] ERROR: cannot specify output root (IIIF_INGEST_OUTPUT_ROOT) and output bucket (IIIF_INGEST_OUTPUT_BUCKET)")
-		os.Exit(1)
+	// conversion configuration
+	add("ConvertBinary        = [%s]", cfg.ConvertBinary)
+	add("ConvertSuffix        = [%s]", cfg.ConvertSuffix)
+	add("ConvertVersion       = [%s]", cfg.ConvertVersion)
+	add("ConvertStdin         = [%s]", cfg.ConvertStdin)
+	add("ConvertStdout        = [%s]", cfg.ConvertStdout)
+	add("DeleteSource         = [%t]", cfg.DeleteSource)
+	add("SourceDisposition    = [%s]", cfg.SourceDisposition)
+	add("ArchiveDestination   = [%s]", cfg.ArchiveDestination)
+	add("ArchiveStorageClass  = [%s]", cfg.ArchiveStorageClass)
+	add("ProcessedTag         = [%s]", cfg.ProcessedTag)
+	add("QuarantineDest       = [%s]", cfg.QuarantineDestination)
+	add("AuditLog             = [%s]", cfg.AuditLog)
+
+	// sort so the output is stable
+	types := make([]string, 0, len(cfg.ConvertOptions))
+	for k := range cfg.ConvertOptions {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	for _, k := range types {
+		add("Convert options map  = [%s ==> %s]", k, cfg.ConvertOptions[k])
+	}
+	types = make([]string, 0, len(cfg.DiskFactors))
+	for k := range cfg.DiskFactors {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	for _, k := range types {
+		add("Disk factor map      = [%s ==> %g]", k, cfg.DiskFactors[k])
+	}
+
+	// output configuration
+	add("OutputFSRoot         = [%s]", cfg.OutputFSRoot)
+	add("OutputBucket         = [%s]", cfg.OutputBucket)
+	add("Outputs              = [%s]", cfg.Outputs)
+	add("OutputKeyPrefix      = [%s]", cfg.OutputKeyPrefix)
+	for _, t := range cfg.OutputTargets {
+		add("Output target        = [%s]", t)
+	}
+	add("OutputMD5            = [%t]", cfg.OutputMD5)
+	add("OutputDirMode        = [%#o]", fileModeToUnix(cfg.OutputDirMode))
+	add("OutputFileMode       = [%#o]", fileModeToUnix(cfg.OutputFileMode))
+	add("OutputGroup          = [%s]", cfg.OutputGroup)
+	add("OutputShardDepth     = [%d]", cfg.OutputShardDepth)
+	add("OutputShardWidth     = [%d]", cfg.OutputShardWidth)
+	add("OutputSidecar        = [%t]", cfg.OutputSidecar)
+	add("CompletionEvents     = [%t]", cfg.CompletionEvents)
+	add("OutputCacheControl   = [%s]", cfg.OutputCacheControl)
+	add("OutputStorageClass   = [%s]", cfg.OutputStorageClass)
+	for _, k := range sortedKeys(cfg.OutputContentTypes) {
+		add("Content type map     = [%s ==> %s]", k, cfg.OutputContentTypes[k])
+	}
+	for _, k := range sortedKeys(cfg.OutputMetadata) {
+		add("Output metadata      = [%s ==> %s]", k, cfg.OutputMetadata[k])
+	}
+	for _, k := range sortedKeys(cfg.OutputTags) {
+		add("Output tag           = [%s ==> %s]", k, cfg.OutputTags[k])
+	}
+
+	for _, r := range cfg.Routes {
+		add("Output route         = [bucket: '%s', prefix: '%s' ==> %s]", r.SourceBucket, r.KeyPrefix, r.Destination)
 	}
 
-	return &cfg
+	for _, r := range cfg.Rules {
+		// include the rule name on each line so configuration differences are clear
+		add("Routing rule '%s' match       = [bucket: '%s', prefix: '%s', format: '%s', regex: '%s']", r.Name, r.SourceBucket, r.KeyPrefix, r.Format, r.InputNameRegex)
+		add("Routing rule '%s' select      = [template: '%s', suffix: '%s', options: '%s']", r.Name, r.OutputNameTemplate, r.ConvertSuffix, r.ConvertOptions)
+		if r.Destination != (Destination{}) {
+			add("Routing rule '%s' destination = [%s]", r.Name, r.Destination)
+		}
+	}
+
+	return lines
+}
+
+// are source objects ever removed, in which case the removals must be audited
+func (cfg ServiceConfig) auditRequired() bool {
+	return cfg.SourceDisposition == dispositionDelete || cfg.SourceDisposition == dispositionArchive ||
+		cfg.QuarantineDestination.isSet() == true
+}
+
+// the keys of a map in order, so validation and descriptions are stable
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hide a secret value when describing the configuration
+func redact(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// the default output destination, the output root when both the output root and the output bucket are written
+// (the bucket is an output target). Otherwise whichever is set; both being set is rejected when validated
+func (cfg ServiceConfig) defaultDestination() Destination {
+	if cfg.Outputs == outputsBoth {
+		return Destination{FSRoot: cfg.OutputFSRoot, KeyPrefix: cfg.OutputKeyPrefix}
+	}
+	return Destination{FSRoot: cfg.OutputFSRoot, Bucket: cfg.OutputBucket, KeyPrefix: cfg.OutputKeyPrefix}
 }
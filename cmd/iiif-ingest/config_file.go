@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// this describes the structure of the optional configuration file. The file may be YAML or JSON (JSON
+// being a subset of YAML). Scalar values use the same semantics as the corresponding environment variables
+// and any environment variable that is set overrides the file value. The S3 credentials are only taken from
+// the environment so they are not kept in the file.
+
+type configFile struct {
+
+	// service configuration
+	InQueue         *string `yaml:"in_queue"`
+	EventQueue      *string `yaml:"event_queue"`
+	ObjectStore     *string `yaml:"object_store"`
+	S3Endpoint      *string `yaml:"s3_endpoint"`
+	S3Region        *string `yaml:"s3_region"`
+	S3PathStyle     *bool   `yaml:"s3_path_style"`
+	S3PartSize      *int    `yaml:"s3_part_size"`
+	S3Concurrency   *int    `yaml:"s3_concurrency"`
+	S3MaxRetries    *int    `yaml:"s3_max_retries"`
+	PollTimeOut     *int64  `yaml:"poll_timeout"`
+	WorkDir         *string `yaml:"work_dir"`
+	WorkerQueueSize *int    `yaml:"work_queue_size"`
+	ReceiveBatch    *int    `yaml:"receive_batch"`
+	Workers         *int    `yaml:"workers"`
+
+	// configuration reload
+	ConfigPollInterval *int `yaml:"config_poll_interval"`
+
+	// adaptive workers
+	AdaptiveWorkers *bool `yaml:"adaptive_workers"`
+	MinWorkers      *int  `yaml:"min_workers"`
+	AdaptInterval   *int  `yaml:"adapt_interval"`
+	CPUHigh         *int  `yaml:"cpu_high"`
+	MemoryHigh      *int  `yaml:"memory_high"`
+
+	// the ordered worker lanes
+	Lanes     []configFileLane `yaml:"lanes"`
+	LogLevel  *string          `yaml:"log_level"`
+	LogFormat *string          `yaml:"log_format"`
+
+	// HTTP server, job history and tracing
+	HttpListen     *string `yaml:"http_listen"`
+	JobStore       *string `yaml:"job_store"`
+	TraceExporter  *string `yaml:"trace_exporter"`
+	StuckThreshold *int    `yaml:"stuck_threshold"`
+
+	// the local queue
+	LocalVisibility *int `yaml:"local_visibility_timeout"`
+
+	// conversion configuration
+	ConvertBinary  *string           `yaml:"convert_bin"`
+	ConvertSuffix  *string           `yaml:"convert_suffix"`
+	ConvertVersion *string           `yaml:"convert_version_opt"`
+	ConvertStdin   *string           `yaml:"convert_stdin_arg"`
+	ConvertStdout  *string           `yaml:"convert_stdout_arg"`
+	DeleteSource   *bool             `yaml:"delete_source"`
+	ConvertOptions map[string]string `yaml:"convert_options"`
+
+	// work directory admission
+	MinFreeSpace *int               `yaml:"min_free_space"`
+	DiskWait     *int               `yaml:"disk_wait"`
+	DiskFactors  map[string]float64 `yaml:"disk_factors"`
+
+	// source object disposition
+	SourceDisposition   *string `yaml:"source_disposition"`
+	ArchiveDest         *string `yaml:"archive_dest"`
+	ArchiveStorageClass *string `yaml:"archive_storage_class"`
+	ProcessedTag        *string `yaml:"processed_tag"`
+	QuarantineDest      *string `yaml:"quarantine_dest"`
+	AuditLog            *string `yaml:"audit_log"`
+
+	// output configuration
+	OutputFSRoot       *string           `yaml:"output_fs_root"`
+	OutputBucket       *string           `yaml:"output_bucket"`
+	Outputs            *string           `yaml:"outputs"`
+	OutputPrefix       *string           `yaml:"output_prefix"`
+	OutputMD5          *bool             `yaml:"output_md5"`
+	OutputDirMode      *string           `yaml:"output_dir_mode"`
+	OutputFileMode     *string           `yaml:"output_file_mode"`
+	OutputGroup        *string           `yaml:"output_group"`
+	OutputShardDepth   *int              `yaml:"output_shard_depth"`
+	OutputShardWidth   *int              `yaml:"output_shard_width"`
+	OutputSidecar      *bool             `yaml:"output_sidecar"`
+	CompletionEvents   *bool             `yaml:"completion_events"`
+	OutputCacheControl *string           `yaml:"output_cache_control"`
+	OutputStorageClass *string           `yaml:"output_storage_class"`
+	ContentTypes       map[string]string `yaml:"content_types"`
+	OutputMetadata     map[string]string `yaml:"output_metadata"`
+	OutputTags         map[string]string `yaml:"output_tags"`
+
+	// the additional output targets
+	OutputTargets []configFileTarget `yaml:"output_targets"`
+
+	// the ordered output routes and routing rules
+	Routes []configFileRoute `yaml:"routes"`
+	Rules  []configFileRule  `yaml:"rules"`
+}
+
+type configFileLane struct {
+	Name    string `yaml:"name"`
+	MaxSize int64  `yaml:"max_size"`
+	Workers int    `yaml:"workers"`
+}
+
+type configFileTarget struct {
+	OutputFSRoot string `yaml:"output_fs_root"`
+	OutputBucket string `yaml:"output_bucket"`
+	OutputPrefix string `yaml:"output_prefix"`
+	Policy       string `yaml:"policy"`
+}
+
+type configFileRoute struct {
+	SourceBucket string `yaml:"source_bucket"`
+	KeyPrefix    string `yaml:"key_prefix"`
+	OutputFSRoot string `yaml:"output_fs_root"`
+	OutputBucket string `yaml:"output_bucket"`
+	OutputPrefix string `yaml:"output_prefix"`
+}
+
+type configFileRule struct {
+	Name  string              `yaml:"name"`
+	Match configFileRuleMatch `yaml:"match"`
+
+	ConvertOptions string `yaml:"convert_options"`
+	NameTemplate   string `yaml:"name_template"`
+	Suffix         string `yaml:"suffix"`
+	OutputFSRoot   string `yaml:"output_fs_root"`
+	OutputBucket   string `yaml:"output_bucket"`
+	OutputPrefix   string `yaml:"output_prefix"`
+}
+
+type configFileRuleMatch struct {
+	SourceBucket string `yaml:"source_bucket"`
+	KeyPrefix    string `yaml:"key_prefix"`
+	Regex        string `yaml:"regex"`
+	Format       string `yaml:"format"`
+}
+
+// load and decode the configuration file, unknown (e.g. misspelled) keys are an error rather than being ignored
+func loadConfigFile(filename string) (*configFile, error) {
+
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var cf configFile
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err = dec.Decode(&cf)
+	// an empty file is an empty configuration
+	if err != nil && errors.Is(err, io.EOF) == false {
+		return nil, fmt.Errorf("decoding %s (%s)", filename, err.Error())
+	}
+
+	return &cf, nil
+}
+
+// the scalar file values keyed by the name of the environment variable they correspond to
+func (cf *configFile) settings() map[string]string {
+
+	settings := make(map[string]string)
+	setString := func(env string, val *string) {
+		if val != nil {
+			settings[env] = *val
+		}
+	}
+	setInt := func(env string, val *int) {
+		if val != nil {
+			settings[env] = strconv.Itoa(*val)
+		}
+	}
+	setBool := func(env string, val *bool) {
+		if val != nil {
+			settings[env] = strconv.FormatBool(*val)
+		}
+	}
+
+	setString("IIIF_INGEST_IN_QUEUE", cf.InQueue)
+	setString("IIIF_INGEST_EVENT_QUEUE", cf.EventQueue)
+	setString("IIIF_INGEST_OBJECT_STORE", cf.ObjectStore)
+	setString("IIIF_INGEST_S3_ENDPOINT", cf.S3Endpoint)
+	setString("IIIF_INGEST_S3_REGION", cf.S3Region)
+	setBool("IIIF_INGEST_S3_PATH_STYLE", cf.S3PathStyle)
+	setInt("IIIF_INGEST_S3_PART_SIZE", cf.S3PartSize)
+	setInt("IIIF_INGEST_S3_CONCURRENCY", cf.S3Concurrency)
+	setInt("IIIF_INGEST_S3_MAX_RETRIES", cf.S3MaxRetries)
+	if cf.PollTimeOut != nil {
+		settings["IIIF_INGEST_QUEUE_POLL_TIMEOUT"] = strconv.FormatInt(*cf.PollTimeOut, 10)
+	}
+	setString("IIIF_INGEST_WORK_DIR", cf.WorkDir)
+	setInt("IIIF_INGEST_WORK_QUEUE_SIZE", cf.WorkerQueueSize)
+	setInt("IIIF_INGEST_RECEIVE_BATCH", cf.ReceiveBatch)
+	setInt("IIIF_INGEST_WORKERS", cf.Workers)
+	setInt("IIIF_INGEST_CONFIG_POLL_INTERVAL", cf.ConfigPollInterval)
+	setBool("IIIF_INGEST_ADAPTIVE_WORKERS", cf.AdaptiveWorkers)
+	setInt("IIIF_INGEST_MIN_WORKERS", cf.MinWorkers)
+	setInt("IIIF_INGEST_ADAPT_INTERVAL", cf.AdaptInterval)
+	setInt("IIIF_INGEST_CPU_HIGH", cf.CPUHigh)
+	setInt("IIIF_INGEST_MEMORY_HIGH", cf.MemoryHigh)
+	setString("IIIF_INGEST_LOG_LEVEL", cf.LogLevel)
+	setString("IIIF_INGEST_LOG_FORMAT", cf.LogFormat)
+	setString("IIIF_INGEST_HTTP_LISTEN", cf.HttpListen)
+	setString("IIIF_INGEST_JOB_STORE", cf.JobStore)
+	setString("IIIF_INGEST_TRACE_EXPORTER", cf.TraceExporter)
+	setInt("IIIF_INGEST_STUCK_THRESHOLD", cf.StuckThreshold)
+	setInt("IIIF_INGEST_LOCAL_VISIBILITY_TIMEOUT", cf.LocalVisibility)
+
+	setString("IIIF_INGEST_CONVERT_BIN", cf.ConvertBinary)
+	setString("IIIF_INGEST_CONVERT_SUFFIX", cf.ConvertSuffix)
+	setString("IIIF_INGEST_CONVERT_VERSION_OPT", cf.ConvertVersion)
+	setString("IIIF_INGEST_CONVERT_STDIN_ARG", cf.ConvertStdin)
+	setString("IIIF_INGEST_CONVERT_STDOUT_ARG", cf.ConvertStdout)
+	setBool("IIIF_INGEST_DELETE_SOURCE", cf.DeleteSource)
+	setInt("IIIF_INGEST_MIN_FREE_SPACE", cf.MinFreeSpace)
+	setInt("IIIF_INGEST_DISK_WAIT", cf.DiskWait)
+
+	setString("IIIF_INGEST_SOURCE_DISPOSITION", cf.SourceDisposition)
+	setString("IIIF_INGEST_ARCHIVE_DEST", cf.ArchiveDest)
+	setString("IIIF_INGEST_ARCHIVE_STORAGE_CLASS", cf.ArchiveStorageClass)
+	setString("IIIF_INGEST_PROCESSED_TAG", cf.ProcessedTag)
+	setString("IIIF_INGEST_QUARANTINE_DEST", cf.QuarantineDest)
+	setString("IIIF_INGEST_AUDIT_LOG", cf.AuditLog)
+
+	setString("IIIF_INGEST_OUTPUT_FS_ROOT", cf.OutputFSRoot)
+	setString("IIIF_INGEST_OUTPUT_BUCKET", cf.OutputBucket)
+	setString("IIIF_INGEST_OUTPUTS", cf.Outputs)
+	setString("IIIF_INGEST_OUTPUT_PREFIX", cf.OutputPrefix)
+	setBool("IIIF_INGEST_OUTPUT_MD5", cf.OutputMD5)
+	setString("IIIF_INGEST_OUTPUT_DIR_MODE", cf.OutputDirMode)
+	setString("IIIF_INGEST_OUTPUT_FILE_MODE", cf.OutputFileMode)
+	setString("IIIF_INGEST_OUTPUT_GROUP", cf.OutputGroup)
+	setInt("IIIF_INGEST_OUTPUT_SHARD_DEPTH", cf.OutputShardDepth)
+	setInt("IIIF_INGEST_OUTPUT_SHARD_WIDTH", cf.OutputShardWidth)
+	setBool("IIIF_INGEST_OUTPUT_SIDECAR", cf.OutputSidecar)
+	setBool("IIIF_INGEST_COMPLETION_EVENTS", cf.CompletionEvents)
+	setString("IIIF_INGEST_OUTPUT_CACHE_CONTROL", cf.OutputCacheControl)
+	setString("IIIF_INGEST_OUTPUT_STORAGE_CLASS", cf.OutputStorageClass)
+
+	return settings
+}
+
+// the file routing rules in our internal representation
+func (cf *configFile) routingRules() []RoutingRule {
+
+	rules := make([]RoutingRule, 0, len(cf.Rules))
+	for ix, r := range cf.Rules {
+		name := r.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("rule %02d", ix+1)
+		}
+		rules = append(rules, RoutingRule{
+			Name:               name,
+			SourceBucket:       r.Match.SourceBucket,
+			KeyPrefix:          r.Match.KeyPrefix,
+			InputNameRegex:     r.Match.Regex,
+			Format:             normalizeFormat(r.Match.Format),
+			ConvertOptions:     r.ConvertOptions,
+			OutputNameTemplate: r.NameTemplate,
+			ConvertSuffix:      r.Suffix,
+			Destination:        fileDestination(r.OutputFSRoot, r.OutputBucket, r.OutputPrefix),
+		})
+	}
+	return rules
+}
+
+// the file output routes in our internal representation
+func (cf *configFile) outputRoutes() []OutputRoute {
+
+	routes := make([]OutputRoute, 0, len(cf.Routes))
+	for _, r := range cf.Routes {
+		routes = append(routes, OutputRoute{
+			SourceBucket: r.SourceBucket,
+			KeyPrefix:    r.KeyPrefix,
+			Destination:  fileDestination(r.OutputFSRoot, r.OutputBucket, r.OutputPrefix),
+		})
+	}
+	return routes
+}
+
+// the file output targets in our internal representation, the policy defaults to required
+func (cf *configFile) outputTargets() []OutputTarget {
+
+	targets := make([]OutputTarget, 0, len(cf.OutputTargets))
+	for _, t := range cf.OutputTargets {
+		policy := t.Policy
+		if len(policy) == 0 {
+			policy = policyRequired
+		}
+		targets = append(targets, OutputTarget{
+			Destination: fileDestination(t.OutputFSRoot, t.OutputBucket, t.OutputPrefix),
+			Policy:      policy,
+		})
+	}
+	return targets
+}
+
+func (cf *configFile) workerLanes() []Lane {
+
+	lanes := make([]Lane, 0, len(cf.Lanes))
+	for _, l := range cf.Lanes {
+		lanes = append(lanes, Lane{Name: l.Name, MaxSize: l.MaxSize, Workers: l.Workers})
+	}
+	return lanes
+}
+
+func fileDestination(fsRoot string, bucket string, prefix string) Destination {
+	return Destination{FSRoot: fsRoot, Bucket: bucket, KeyPrefix: strings.Trim(prefix, "/")}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a complete configuration file, the converter only has to be runnable
+var testConfigFile = `
+in_queue: inbound
+poll_timeout: 20
+work_dir: /tmp
+work_queue_size: 4
+workers: 2
+convert_bin: sh
+convert_suffix: jp2
+delete_source: false
+convert_options:
+  "*": "-rate 1"
+  ".tif": "-rate 2"
+output_bucket: derivatives
+rules:
+  - name: special collections
+    match:
+      source_bucket: masters
+      key_prefix: special/
+      regex: '^special/(\w+)$'
+      format: TIFF
+    name_template: 'sc/{:1}'
+    suffix: jpx
+    output_prefix: /iiif/
+  - match:
+      regex: '^(\w+)/(\w+)$'
+    name_template: '{:1}/{:2}'
+`
+
+// load the configuration from a file with the contents specified
+func loadTestConfigFile(t *testing.T, contents string) (*ServiceConfig, []error) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IIIF_INGEST_CONFIG_FILE", filename)
+	return loadConfiguration()
+}
+
+func TestConfigFileRules(t *testing.T) {
+
+	cfg, errs := loadTestConfigFile(t, testConfigFile)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors %v", errs)
+	}
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %+v", cfg.Rules)
+	}
+
+	r := cfg.Rules[0]
+	if r.Name != "special collections" || r.SourceBucket != "masters" || r.KeyPrefix != "special/" ||
+		r.InputNameRegex != `^special/(\w+)$` || r.Format != normalizeFormat("TIFF") || r.OutputNameTemplate != "sc/{:1}" ||
+		r.ConvertSuffix != "jpx" || r.Destination != (Destination{KeyPrefix: "iiif"}) {
+		t.Fatalf("unexpected first rule %+v", r)
+	}
+	// an unnamed rule is named by position and uses the service suffix
+	r = cfg.Rules[1]
+	if r.Name != "rule 02" || r.ConvertSuffix != "jp2" || r.Destination.isSet() == true {
+		t.Fatalf("unexpected second rule %+v", r)
+	}
+	if cfg.ConvertOptions["*"] != "-rate 1" || cfg.ConvertOptions[".tif"] != "-rate 2" {
+		t.Fatalf("unexpected convert options %v", cfg.ConvertOptions)
+	}
+}
+
+func TestConfigEnvironmentOverridesFile(t *testing.T) {
+
+	t.Setenv("IIIF_INGEST_WORKERS", "3")
+	t.Setenv("IIIF_INGEST_OUTPUT_BUCKET", "elsewhere")
+	t.Setenv("IIIF_INGEST_CONVERT_OPTS_01", "*=-rate 5")
+	t.Setenv("IIIF_INGEST_NAME_MAP_01", `^(\w+)$={:1}`)
+	cfg, errs := loadTestConfigFile(t, testConfigFile)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors %v", errs)
+	}
+
+	// environment scalars replace the file values, unset ones keep them
+	if cfg.Workers != 3 || cfg.OutputBucket != "elsewhere" || cfg.InQueueName != "inbound" || cfg.PollTimeOut != 20 {
+		t.Fatalf("unexpected settings %+v", cfg)
+	}
+	// environment options replace those of the same type, environment rules follow the file rules
+	if cfg.ConvertOptions["*"] != "-rate 5" || cfg.ConvertOptions[".tif"] != "-rate 2" {
+		t.Fatalf("unexpected convert options %v", cfg.ConvertOptions)
+	}
+	if len(cfg.Rules) != 3 || cfg.Rules[2].Name != "name map 01" {
+		t.Fatalf("unexpected rules %+v", cfg.Rules)
+	}
+}
+
+func TestConfigFileUnknownKey(t *testing.T) {
+
+	// a misspelled key is reported rather than ignored
+	contents := strings.Replace(testConfigFile, "name_template: '{:1}/{:2}'", "name_templat: '{:1}/{:2}'", 1)
+	_, errs := loadTestConfigFile(t, contents)
+	if len(errs) != 1 || strings.Contains(errs[0].Error(), "name_templat") == false {
+		t.Fatalf("expected an unknown key error, got %v", errs)
+	}
+}
+
+func TestConfigFileSettings(t *testing.T) {
+
+	contents := testConfigFile + `s3_path_style: true
+config_poll_interval: 10
+adaptive_workers: true
+min_workers: 1
+trace_exporter: stdout
+stuck_threshold: 600
+convert_version_opt: --vips-version
+min_free_space: 512
+log_format: text
+`
+	cfg, errs := loadTestConfigFile(t, contents)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors %v", errs)
+	}
+	if cfg.S3PathStyle != true || cfg.ConfigPollInterval != 10 || cfg.AdaptiveWorkers != true || cfg.TraceExporter != "stdout" ||
+		cfg.StuckThreshold != 600 || cfg.ConvertVersion != "--vips-version" || cfg.MinFreeSpace != 512 || cfg.DeleteSource != false || cfg.LogFormat != "text" {
+		t.Fatalf("unexpected settings %+v", cfg)
+	}
+	// a local queue message stays hidden for as long as a job can run unless configured otherwise
+	if cfg.LocalVisibility != 600 {
+		t.Fatalf("expected the local visibility timeout to follow the stuck threshold, got %d", cfg.LocalVisibility)
+	}
+	t.Setenv("IIIF_INGEST_LOCAL_VISIBILITY_TIMEOUT", "7200")
+	cfg, errs = loadTestConfigFile(t, contents)
+	if len(errs) != 0 || cfg.LocalVisibility != 7200 {
+		t.Fatalf("unexpected local visibility timeout %d (%v)", cfg.LocalVisibility, errs)
+	}
+}
+
+func TestConfigConvertOptionCount(t *testing.T) {
+
+	// too many options for the conversion command is a configuration error rather than a failure mid job
+	contents := strings.Replace(testConfigFile, `".tif": "-rate 2"`, `".tif": "-a 1 -b 2 -c 3"`, 1)
+	_, errs := loadTestConfigFile(t, contents)
+	if len(errs) != 1 || strings.Contains(errs[0].Error(), "'.tif'") == false {
+		t.Fatalf("expected a conversion options error, got %v", errs)
+	}
+	contents = strings.Replace(testConfigFile, "suffix: jpx", "suffix: jpx\n    convert_options: -a 1 -b 2 -c 3", 1)
+	_, errs = loadTestConfigFile(t, contents)
+	if len(errs) != 1 || strings.Contains(errs[0].Error(), "special collections") == false {
+		t.Fatalf("expected a routing rule conversion options error, got %v", errs)
+	}
+}
+
+func TestConfigCheckCommand(t *testing.T) {
+
+	// the check changes the configuration file setting, restore it afterwards
+	t.Setenv("IIIF_INGEST_CONFIG_FILE", "")
+
+	tests := []struct {
+		name     string
+		contents string
+		status   int
+	}{
+		{"valid", testConfigFile, 0},
+		{"unknown key", strings.Replace(testConfigFile, "suffix: jpx", "sufix: jpx", 1), 1},
+		{"too many conversion options", strings.Replace(testConfigFile, `".tif": "-rate 2"`, `".tif": "-a 1 -b 2 -c 3"`, 1), 1},
+	}
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "config.yaml")
+		err := os.WriteFile(filename, []byte(tt.contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := runCommand([]string{"config", "check", "-file", filename}); status != tt.status {
+			t.Errorf("%s: expected exit status %d, got %d", tt.name, tt.status, status)
+		}
+	}
+	if status := runCommand([]string{"config", "check", "-unknown"}); status != 2 {
+		t.Errorf("expected exit status 2 for a bad flag, got %d", status)
+	}
+}
+
+func TestCommandSettingsUseConfigFile(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte("http_listen: ':9090'\njob_store: sqlite:///data/jobs.db\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IIIF_INGEST_CONFIG_FILE", filename)
+	t.Setenv("IIIF_INGEST_JOB_STORE", "")
+
+	// the file value is used unless the environment variable is set (even to empty)
+	listen, err := commandSetting("IIIF_INGEST_HTTP_LISTEN", ":8080")
+	if err != nil || listen != ":9090" {
+		t.Fatalf("expected the file listen address, got '%s' (%v)", listen, err)
+	}
+	store, err := commandSetting("IIIF_INGEST_JOB_STORE", "")
+	if err != nil || store != "" {
+		t.Fatalf("expected the environment job store, got '%s' (%v)", store, err)
+	}
+}
+
+func TestConfigOutputs(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		outputs string // the IIIF_INGEST_OUTPUTS value (empty if not set)
+		root    string
+		targets int  // the expected number of output targets
+		valid   bool // is the configuration expected to be valid
+	}{
+		{"bucket only", "", "", 0, true},
+		{"root and bucket without asking", "", "/tmp", 0, false},
+		{"root and bucket", outputsBoth, "/tmp", 1, true},
+		{"both without a root", outputsBoth, "", 0, false},
+		{"unsupported", "all", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.outputs) != 0 {
+				t.Setenv("IIIF_INGEST_OUTPUTS", tt.outputs)
+			}
+			if len(tt.root) != 0 {
+				t.Setenv("IIIF_INGEST_OUTPUT_FS_ROOT", tt.root)
+			}
+			cfg, errs := loadTestConfigFile(t, testConfigFile)
+			if (len(errs) == 0) != tt.valid {
+				t.Fatalf("expected valid %t, got errors %v", tt.valid, errs)
+			}
+			if tt.valid == true && len(cfg.OutputTargets) != tt.targets {
+				t.Fatalf("expected %d output targets, got %+v", tt.targets, cfg.OutputTargets)
+			}
+		})
+	}
+}
+
+func TestConfigFileModes(t *testing.T) {
+
+	tests := []struct {
+		value string
+		mode  os.FileMode
+		valid bool
+	}{
+		{"0750", 0750, true},
+		{"02775", 0775 | os.ModeSetgid, true},
+		{"1777", 0777 | os.ModeSticky, true},
+		{"04755", 0, false}, // setuid
+		{"0", 0, false},
+		{"0999", 0, false},
+	}
+	for _, tt := range tests {
+		var l configLoader
+		t.Setenv("IIIF_INGEST_OUTPUT_DIR_MODE", tt.value)
+		mode := l.envToFileMode("IIIF_INGEST_OUTPUT_DIR_MODE")
+		if (len(l.errs) == 0) != tt.valid || mode != tt.mode {
+			t.Errorf("%s: expected %v (valid %t), got %v %v", tt.value, tt.mode, tt.valid, mode, l.errs)
+		}
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// the work directory space needed per source byte by default, beyond the download itself
+var defaultDiskFactor = 1.0
+
+// how often the free space is checked while waiting for space
+var diskRecheckInterval = 5 * time.Second
+
+var diskReservedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_disk_reserved_bytes",
+	Help: "The work directory space reserved by running jobs",
+})
+
+var diskReservations = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_disk_reservations",
+	Help: "The number of running jobs holding a work directory reservation",
+})
+
+var diskWaitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_disk_wait_seconds",
+	Help:    "The time jobs waited for work directory space",
+	Buckets: durationBuckets,
+}, []string{"outcome"})
+
+// diskBudget admits jobs to the work directory so concurrent jobs do not fill it. Each job reserves its
+// estimated space before downloading and releases it when done. The free space reported by the filesystem
+// already includes anything written by running jobs so the accounting errs on the side of caution
+type diskBudget struct {
+	sync.Mutex
+	reserved uint64                           // the total outstanding reservations
+	changed  chan struct{}                    // closed when a reservation is released
+	free     func(dir string) (uint64, error) // the free space in a directory
+}
+
+func newDiskBudget(free func(dir string) (uint64, error)) *diskBudget {
+	return &diskBudget{changed: make(chan struct{}), free: free}
+}
+
+// diskReservation is the work directory space held by a single job
+type diskReservation struct {
+	budget *diskBudget
+	size   uint64
+	once   sync.Once
+}
+
+// reserve space in the work directory, leaving at least minFree. If there is not enough space wait up to the
+// specified time for other jobs to release theirs, then give up so the message can be redelivered later
+// (perhaps to another instance)
+func (b *diskBudget) reserve(dir string, size uint64, minFree uint64, wait time.Duration) (*diskReservation, error) {
+
+	start := time.Now()
+	for {
+		b.Lock()
+		free, err := b.free(dir)
+		if err != nil {
+			b.Unlock()
+			return nil, fmt.Errorf("cannot determine free space in %s (%s)", dir, err.Error())
+		}
+		if free >= b.reserved+size+minFree {
+			b.reserved += size
+			diskReservedBytes.Set(float64(b.reserved))
+			diskReservations.Inc()
+			b.Unlock()
+			diskWaitDuration.WithLabelValues(outcomeSuccess).Observe(time.Since(start).Seconds())
+			return &diskReservation{budget: b, size: size}, nil
+		}
+
+		// nothing will be released if nothing is reserved
+		reserved := b.reserved
+		changed := b.changed
+		b.Unlock()
+		remaining := wait - time.Since(start)
+		if reserved == 0 || remaining <= 0 {
+			diskWaitDuration.WithLabelValues(outcomeFailure).Observe(time.Since(start).Seconds())
+			return nil, fmt.Errorf("insufficient work directory space, need %d bytes with %d bytes free, %d bytes reserved by other jobs and %d bytes to be left free",
+				size, free, reserved, minFree)
+		}
+
+		// space may also be freed outside of the service
+		select {
+		case <-changed:
+		case <-time.After(min(remaining, diskRecheckInterval)):
+		}
+	}
+}
+
+// release the reservation, it is safe to release more than once
+func (r *diskReservation) release() {
+	r.once.Do(func() {
+		b := r.budget
+		b.Lock()
+		defer b.Unlock()
+		b.reserved -= r.size
+		diskReservedBytes.Set(float64(b.reserved))
+		diskReservations.Dec()
+		close(b.changed)
+		b.changed = make(chan struct{})
+	})
+}
+
+// the work directory space a job is expected to need; the download (unless the source is streamed) plus the
+// converter output and any scratch space, estimated from the source size and the per file type factor
+func diskEstimate(config ServiceConfig, key string, size int64, streamInput bool) uint64 {
+
+	if size <= 0 {
+		return 0
+	}
+	factor, ok := config.DiskFactors[path.Ext(key)]
+	if ok == false {
+		factor = config.DiskFactors["*"]
+	}
+	estimate := float64(size) * factor
+	if streamInput == false {
+		estimate += float64(size)
+	}
+	return uint64(estimate)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// a filesystem with a fixed amount of free space
+func fixedFree(free uint64) func(string) (uint64, error) {
+	return func(string) (uint64, error) { return free, nil }
+}
+
+func TestDiskBudgetAdmits(t *testing.T) {
+
+	b := newDiskBudget(fixedFree(1000))
+	r1, err := b.reserve("/work", 400, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := b.reserve("/work", 400, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.reserved != 800 {
+		t.Fatalf("expected 800 bytes reserved, got %d", b.reserved)
+	}
+	r1.release()
+	r1.release()
+	r2.release()
+	if b.reserved != 0 {
+		t.Fatalf("expected nothing reserved, got %d", b.reserved)
+	}
+}
+
+func TestDiskBudgetDefers(t *testing.T) {
+
+	b := newDiskBudget(fixedFree(1000))
+
+	// too large for the work directory with nothing to wait for
+	_, err := b.reserve("/work", 950, 100, time.Minute)
+	if err == nil {
+		t.Fatal("expected the reservation to be refused")
+	}
+
+	// waits for another job, then gives up
+	r, err := b.reserve("/work", 500, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.release()
+	_, err = b.reserve("/work", 500, 100, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the reservation to be refused")
+	}
+}
+
+func TestDiskBudgetWaits(t *testing.T) {
+
+	b := newDiskBudget(fixedFree(1000))
+	r, err := b.reserve("/work", 600, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.release()
+	}()
+
+	r2, err := b.reserve("/work", 600, 0, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.release()
+}
+
+func TestDiskEstimate(t *testing.T) {
+
+	cfg := ServiceConfig{DiskFactors: map[string]float64{"*": 1.0, ".tif": 2.5}}
+	if e := diskEstimate(cfg, "coll/item.tif", 100, false); e != 350 {
+		t.Fatalf("unexpected estimate %d", e)
+	}
+	if e := diskEstimate(cfg, "coll/item.tif", 100, true); e != 250 {
+		t.Fatalf("unexpected estimate %d", e)
+	}
+	if e := diskEstimate(cfg, "coll/item.jpg", 100, false); e != 200 {
+		t.Fatalf("unexpected estimate %d", e)
+	}
+	if e := diskEstimate(cfg, "coll/item.jpg", 0, false); e != 0 {
+		t.Fatalf("unexpected estimate %d", e)
+	}
+}
+
+//
+// end of file
+//
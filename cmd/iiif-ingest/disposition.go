@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// what happens to a source object once it has been successfully processed
+const (
+	dispositionNone    = "none"    // leave it where it is
+	dispositionDelete  = "delete"  // delete it
+	dispositionArchive = "archive" // move it to the archive destination
+	dispositionTag     = "tag"     // tag it as processed
+)
+
+// the default tag applied to processed objects
+var defaultProcessedTag = "iiif-ingest=processed"
+
+// split a tag specification of the form key=value
+func parseTag(spec string) (string, string, error) {
+	s := strings.SplitN(spec, "=", 2)
+	if len(s) != 2 || len(strings.TrimSpace(s[0])) == 0 {
+		return "", "", fmt.Errorf("tag '%s' must be of the form key=value", spec)
+	}
+	return strings.TrimSpace(s[0]), strings.TrimSpace(s[1]), nil
+}
+
+// an output that must exist before the source object is removed
+type requiredOutput struct {
+	dest Destination // where the output was written
+	name string      // the output name within the destination
+}
+
+// the outputs of a job written to required targets, the job destination first
+func requiredOutputs(config ServiceConfig, targets []OutputTarget, outputName string) []requiredOutput {
+	outputs := make([]requiredOutput, 0, len(targets))
+	for _, t := range targets {
+		if t.Policy == policyRequired {
+			outputs = append(outputs, requiredOutput{dest: t.Destination, name: config.targetName(t.Destination, outputName)})
+		}
+	}
+	return outputs
+}
+
+// dispose of a successfully processed source object according to the configured disposition
+func disposeSource(log *slog.Logger, config ServiceConfig, objects ObjectStore, audit AuditLog, outputs []requiredOutput, rec DeletionRecord) error {
+
+	switch config.SourceDisposition {
+	case dispositionDelete:
+		return deleteSource(log, objects, audit, outputs, rec)
+	case dispositionArchive:
+		return archiveSource(log, objects, audit, config.ArchiveDestination, config.ArchiveStorageClass, outputs, rec)
+	case dispositionTag:
+		tagKey, tagValue, _ := parseTag(config.ProcessedTag)
+		log.Info("tagging source object", "tag", config.ProcessedTag)
+		err := objects.TagObject(rec.SourceBucket, rec.SourceKey, tagKey, tagValue)
+		if err != nil {
+			log.Error("failed to tag source object", "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// delete the source object once the outputs have been verified to exist and are non-empty. The deletion is
+// recorded in the audit log before it happens and it does not happen if it cannot be recorded
+func deleteSource(log *slog.Logger, objects ObjectStore, audit AuditLog, outputs []requiredOutput, rec DeletionRecord) error {
+
+	err := verifyOutputs(log, objects, audit, outputs, &rec)
+	if err != nil {
+		return err
+	}
+
+	rec.Action = auditDeleted
+	return removeSource(log, objects, audit, rec)
+}
+
+// move the source object to the archive once the outputs have been verified, the archive copy is verified
+// before the source is removed
+func archiveSource(log *slog.Logger, objects ObjectStore, audit AuditLog, archive Destination, storageClass string, outputs []requiredOutput, rec DeletionRecord) error {
+
+	err := verifyOutputs(log, objects, audit, outputs, &rec)
+	if err != nil {
+		return err
+	}
+
+	log.Info("archiving source object", "archive", archive.String(), "storage_class", storageClass)
+	err = moveSource(log, objects, audit, archive, storageClass, &rec)
+	if err != nil {
+		return err
+	}
+
+	rec.Action = auditArchived
+	return removeSource(log, objects, audit, rec)
+}
+
+// move a source object that could not be processed to the quarantine destination, a sidecar JSON object
+// alongside it describes why
+func quarantineSource(log *slog.Logger, objects ObjectStore, audit AuditLog, quarantine Destination, rec DeletionRecord, reason error) error {
+
+	rec.Time = time.Now()
+	rec.Error = reason.Error()
+
+	log.Warn("quarantining source object", "quarantine", quarantine.String(), "reason", reason)
+
+	// the copy is verified against the source size, ask the store if the event did not include it
+	var err error
+	if rec.SourceSize <= 0 {
+		rec.SourceSize, err = objects.StatObject(rec.SourceBucket, rec.SourceKey)
+		if err != nil {
+			log.Error("failed to determine the source size, not quarantining it", "error", err)
+			return err
+		}
+	}
+
+	err = moveSource(log, objects, audit, quarantine, "", &rec)
+	if err != nil {
+		return err
+	}
+
+	rec.Action = auditQuarantined
+	buf, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		sidecar := fmt.Sprintf("%s.json", path.Join(quarantine.KeyPrefix, rec.SourceKey))
+		err = objects.PutFromBuffer(quarantine.Bucket, sidecar, buf)
+	}
+	if err != nil {
+		log.Error("failed to write quarantine sidecar, not removing source object", "error", err)
+		return err
+	}
+
+	return removeSource(log, objects, audit, rec)
+}
+
+// copy the source object below the specified destination and verify the copy
+func moveSource(log *slog.Logger, objects ObjectStore, audit AuditLog, to Destination, storageClass string, rec *DeletionRecord) error {
+
+	key := path.Join(to.KeyPrefix, rec.SourceKey)
+	rec.Archive = fmt.Sprintf("s3://%s/%s", to.Bucket, key)
+
+	err := objects.CopyObject(rec.SourceBucket, rec.SourceKey, to.Bucket, key, storageClass)
+	if err == nil {
+		var size int64
+		size, err = objects.StatObject(to.Bucket, key)
+		if err == nil && size != rec.SourceSize {
+			err = fmt.Errorf("copy %s is %d bytes, expected %d", rec.Archive, size, rec.SourceSize)
+		}
+	}
+	if err != nil {
+		log.Error("failed to copy source object, not removing it", "to", rec.Archive, "error", err)
+		rec.Action = auditRefused
+		rec.Error = err.Error()
+		auditDeletion(log, audit, *rec)
+		return err
+	}
+	return nil
+}
+
+// verify every required output exists and is non-empty, a failure is recorded in the audit log. The record
+// describes the job destination (the first output)
+func verifyOutputs(log *slog.Logger, objects ObjectStore, audit AuditLog, outputs []requiredOutput, rec *DeletionRecord) error {
+
+	rec.Time = time.Now()
+
+	var err error
+	for ix, out := range outputs {
+		location := out.dest.location(out.name)
+		var size int64
+		size, err = outputSize(objects, out.dest, out.name)
+		if err == nil && size == 0 {
+			err = fmt.Errorf("output %s is empty", location)
+		}
+		if ix == 0 {
+			rec.Output = location
+			rec.OutputSize = size
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Error("output verification failed, not removing source object", "error", err)
+		rec.Action = auditRefused
+		rec.Error = err.Error()
+		auditDeletion(log, audit, *rec)
+		return err
+	}
+	rec.Verified = true
+	return nil
+}
+
+// remove the source object, the removal is recorded in the audit log first and does not happen if it
+// cannot be recorded
+func removeSource(log *slog.Logger, objects ObjectStore, audit AuditLog, rec DeletionRecord) error {
+
+	err := audit.Append(rec)
+	if err != nil {
+		log.Error("failed to write audit record, not removing source object", "error", err)
+		return err
+	}
+
+	log.Info("removing source object", "etag", rec.SourceETag, "checksum", rec.SourceChecksum)
+	err = objects.DeleteObject(rec.SourceBucket, rec.SourceKey)
+	if err != nil {
+		log.Error("failed to remove source object", "error", err)
+		rec.Time = time.Now()
+		rec.Action = auditDeleteFailed
+		rec.Error = err.Error()
+		auditDeletion(log, audit, rec)
+		return err
+	}
+
+	return nil
+}
+
+// write an audit record, failures are logged
+func auditDeletion(log *slog.Logger, audit AuditLog, rec DeletionRecord) {
+	err := audit.Append(rec)
+	if err != nil {
+		log.Error("failed to write audit record", "action", rec.Action, "error", err)
+	}
+}
+
+// the size of the output file or object
+func outputSize(objects ObjectStore, dest Destination, outputFile string) (int64, error) {
+
+	if len(dest.FSRoot) != 0 {
+		fi, err := os.Stat(path.Join(dest.FSRoot, outputFile))
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+
+	return objects.StatObject(dest.Bucket, outputFile)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestDeleteSourceVerifiesRequiredOutputs(t *testing.T) {
+
+	config := testConfig(t, func(cfg *ServiceConfig) {
+		cfg.SourceDisposition = dispositionDelete
+	})
+	targets := []OutputTarget{
+		{Destination: Destination{Bucket: "out"}, Policy: policyRequired},
+		{Destination: Destination{Bucket: "backup"}, Policy: policyRequired},
+		{Destination: Destination{Bucket: "mirror"}, Policy: policyBestEffort},
+	}
+	outputs := requiredOutputs(config, targets, "coll/verify.jp2")
+	if len(outputs) != 2 {
+		t.Fatalf("expected 2 required outputs, got %+v", outputs)
+	}
+
+	tests := []struct {
+		name    string
+		written []string // the buckets the output exists in
+		deleted bool
+	}{
+		{"every required output", []string{"out", "backup"}, true},
+		{"missing required output", []string{"out", "mirror"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := newMemObjectStore()
+			objects.put("in", "coll/verify.tif", []byte("image data"))
+			for _, bucket := range tt.written {
+				objects.put(bucket, "coll/verify.jp2", []byte("image data"))
+			}
+			audit := &memAuditLog{}
+			rec := DeletionRecord{Job: "job", SourceBucket: "in", SourceKey: "coll/verify.tif", SourceSize: 10}
+
+			err := disposeSource(slog.Default(), config, objects, audit, outputs, rec)
+			_, exists := objects.get("in", "coll/verify.tif")
+			if (err == nil) != tt.deleted || exists == tt.deleted {
+				t.Fatalf("expected deleted %t, got error %v and source exists %t", tt.deleted, err, exists)
+			}
+			records := audit.all()
+			if len(records) != 1 || records[0].Output != "s3://out/coll/verify.jp2" || records[0].Verified != tt.deleted {
+				t.Fatalf("unexpected audit records %+v", records)
+			}
+		})
+	}
+}
+
+func TestAuditLogDefaultsToStderr(t *testing.T) {
+
+	t.Setenv("IIIF_INGEST_SOURCE_DISPOSITION", dispositionDelete)
+	cfg, errs := loadTestConfigFile(t, testConfigFile)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors %v", errs)
+	}
+	if cfg.AuditLog != auditLogStderr {
+		t.Fatalf("expected the audit log to default to %s, got '%s'", auditLogStderr, cfg.AuditLog)
+	}
+
+	var buf bytes.Buffer
+	audit := &writerAuditLog{w: &buf}
+	err := audit.Append(DeletionRecord{Job: "job", Action: auditDeleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec DeletionRecord
+	err = json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil || rec.Action != auditDeleted || bytes.HasSuffix(buf.Bytes(), []byte("\n")) == false {
+		t.Fatalf("unexpected audit output %q (%v)", buf.String(), err)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// event types
+const (
+	eventFailed    = "failed"    // an inbound object could not be processed
+	eventCompleted = "completed" // an inbound object was converted (when completion events are enabled)
+)
+
+// Event is published when something happens that downstream systems (or people) need to know about
+type Event struct {
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	Job          string    `json:"job"`
+	Stage        string    `json:"stage,omitempty"`
+	SourceBucket string    `json:"source_bucket"`
+	SourceKey    string    `json:"source_key"`
+	SourceETag   string    `json:"source_etag,omitempty"`
+	SourceSize   int64     `json:"source_size"`
+	Reason       string    `json:"reason,omitempty"`
+	Quarantine   string    `json:"quarantine,omitempty"`
+	Output       string    `json:"output,omitempty"`
+	Outputs      []string  `json:"outputs,omitempty"`
+	OutputSize   int64     `json:"output_size,omitempty"`
+	OutputSHA256 string    `json:"output_sha256,omitempty"`
+	OutputMD5    string    `json:"output_md5,omitempty"`
+}
+
+// EventEmitter publishes events
+type EventEmitter interface {
+	Emit(ctx context.Context, ev Event) error
+}
+
+// create an event emitter that publishes to the named queue (see openQueue). An empty name disables events
+func newEventEmitter(queueName string) (EventEmitter, error) {
+
+	if len(queueName) == 0 {
+		return nullEventEmitter{}, nil
+	}
+
+	queue, err := openQueue(queueName, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &queueEventEmitter{publisher: queue}, nil
+}
+
+// queueEventEmitter publishes events as JSON messages, the message attributes carry the trace context
+type queueEventEmitter struct {
+	publisher MessagePublisher
+}
+
+func (e *queueEventEmitter) Emit(ctx context.Context, ev Event) error {
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	attributes := propagation.MapCarrier{}
+	propagator.Inject(ctx, attributes)
+	attributes["type"] = ev.Type
+	return e.publisher.Publish(payload, attributes)
+}
+
+// nullEventEmitter is used when events are disabled
+type nullEventEmitter struct{}
+
+func (nullEventEmitter) Emit(ctx context.Context, ev Event) error { return nil }
+
+//
+// end of file
+//
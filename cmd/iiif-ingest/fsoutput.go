@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the default mode of created output directories (subject to the umask unless a mode is configured)
+var defaultOutputDirMode os.FileMode = 0755
+
+// the limits of the hashed directory sharding, 4 levels of 4 hex digits is more than enough for anything
+var maxShardDepth = 4
+var maxShardWidth = 4
+
+// fsOutputOptions control how converted files are written to a filesystem destination
+type fsOutputOptions struct {
+	dirMode  os.FileMode // the mode of created directories (0 for the default, subject to the umask)
+	fileMode os.FileMode // the mode of written files (0 to leave it to the umask)
+	gid      int         // the group of created directories and written files (-1 to leave it unchanged)
+}
+
+// the filesystem output options, the group has been validated when the configuration was loaded
+func (cfg ServiceConfig) fsOutputOptions() (fsOutputOptions, error) {
+	opts := fsOutputOptions{dirMode: cfg.OutputDirMode, fileMode: cfg.OutputFileMode, gid: -1}
+	if len(cfg.OutputGroup) != 0 {
+		gid, err := lookupGroup(cfg.OutputGroup)
+		if err != nil {
+			return opts, err
+		}
+		opts.gid = gid
+	}
+	return opts, nil
+}
+
+// the group id for a group name or number
+func lookupGroup(group string) (int, error) {
+	gid, err := strconv.Atoi(group)
+	if err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// the name of an output below a destination. For a filesystem destination with sharding the name is placed
+// below directories named from the leading hex digits of the SHA-256 of the name, e.g. 3f/a2/iiif/coll/item.jp2
+// with a depth of 2 and a width of 2, so no single directory grows too large
+func (cfg ServiceConfig) targetName(dest Destination, name string) string {
+	outputName := dest.outputName(name)
+	if len(dest.FSRoot) == 0 || cfg.OutputShardDepth == 0 {
+		return outputName
+	}
+	sum := sha256.Sum256([]byte(outputName))
+	digits := hex.EncodeToString(sum[:])
+	shards := make([]string, 0, cfg.OutputShardDepth+1)
+	for ix := 0; ix < cfg.OutputShardDepth; ix++ {
+		shards = append(shards, digits[ix*cfg.OutputShardWidth:(ix+1)*cfg.OutputShardWidth])
+	}
+	return path.Join(append(shards, outputName)...)
+}
+
+// create the directories for a file below the root, any that are created (including the root itself) are
+// given the configured mode and group. Missing parents of the root are created but left alone
+func createOutputDirectories(root string, filename string, opts fsOutputOptions) error {
+
+	root = filepath.Clean(root)
+	rel, err := filepath.Rel(root, filepath.Dir(filename))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") == true {
+		return fmt.Errorf("%s is not below %s", filename, root)
+	}
+	return createOutputDirectory(root, filepath.Dir(filename), opts)
+}
+
+// create a directory below (or at) the root and any missing parents, applying the configured mode and group
+// to each one created
+func createOutputDirectory(root string, dir string, opts fsOutputOptions) error {
+
+	dirMode := opts.dirMode
+	if dirMode == 0 {
+		dirMode = defaultOutputDirMode
+	}
+	err := os.Mkdir(dir, dirMode)
+	if os.IsNotExist(err) == true {
+		parent := filepath.Dir(dir)
+		if dir == root {
+			// the parents of the root are not output directories
+			err = os.MkdirAll(parent, defaultOutputDirMode)
+		} else {
+			err = createOutputDirectory(root, parent, opts)
+		}
+		if err == nil {
+			err = os.Mkdir(dir, dirMode)
+		}
+	}
+	// another job may have created it
+	if os.IsExist(err) == true {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the mode is not subject to the umask when configured
+	return setOutputOwnership(dir, opts.dirMode, opts.gid)
+}
+
+// a Unix mode (permissions, setgid and sticky bits) as an os.FileMode
+func unixToFileMode(mode uint32) os.FileMode {
+	fm := os.FileMode(mode & 0777)
+	if mode&02000 != 0 {
+		fm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm
+}
+
+// an os.FileMode as a Unix mode, for display
+func fileModeToUnix(fm os.FileMode) uint32 {
+	mode := uint32(fm.Perm())
+	if fm&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if fm&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// apply the configured mode (if any) and group (if any) to an output file or directory
+func setOutputOwnership(name string, mode os.FileMode, gid int) error {
+	if mode != 0 {
+		err := os.Chmod(name, mode)
+		if err != nil {
+			return err
+		}
+	}
+	if gid >= 0 {
+		return os.Lchown(name, -1, gid)
+	}
+	return nil
+}
+
+//
+// end of file
+//
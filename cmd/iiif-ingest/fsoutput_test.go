@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestTargetName(t *testing.T) {
+
+	cfg := ServiceConfig{OutputShardDepth: 2, OutputShardWidth: 2}
+	fs := Destination{FSRoot: "/mnt/iiif", KeyPrefix: "iiif"}
+
+	// the same name is always in the same shard
+	name := cfg.targetName(fs, "coll/item01.jp2")
+	if name != cfg.targetName(fs, "coll/item01.jp2") || len(name) != len("ab/cd/iiif/coll/item01.jp2") ||
+		name[2] != '/' || name[5:] != "/iiif/coll/item01.jp2" {
+		t.Fatalf("unexpected sharded name '%s'", name)
+	}
+
+	// buckets are not sharded and nothing is without a depth
+	if name = cfg.targetName(Destination{Bucket: "out", KeyPrefix: "iiif"}, "coll/item01.jp2"); name != "iiif/coll/item01.jp2" {
+		t.Fatalf("unexpected bucket name '%s'", name)
+	}
+	cfg.OutputShardDepth = 0
+	if name = cfg.targetName(fs, "coll/item01.jp2"); name != "iiif/coll/item01.jp2" {
+		t.Fatalf("unexpected unsharded name '%s'", name)
+	}
+}
+
+func TestCreateOutputDirectories(t *testing.T) {
+
+	root := t.TempDir()
+	_ = os.Chmod(root, 0700)
+	opts := fsOutputOptions{dirMode: 0750, fileMode: 0640, gid: os.Getgid()}
+
+	filename := filepath.Join(root, "ab", "coll", "item01.jp2")
+	err := createOutputDirectories(root, filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(root, "ab"), filepath.Join(root, "ab", "coll")} {
+		fi, err := os.Stat(dir)
+		if err != nil || fi.Mode().Perm() != 0750 {
+			t.Fatalf("unexpected directory %s %v (%v)", dir, fi.Mode(), err)
+		}
+		if gid := fi.Sys().(*syscall.Stat_t).Gid; int(gid) != opts.gid {
+			t.Fatalf("unexpected group %d for %s", gid, dir)
+		}
+	}
+	// the root is left alone
+	fi, _ := os.Stat(root)
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("unexpected root mode %v", fi.Mode())
+	}
+
+	_ = os.WriteFile(filename, nil, 0600)
+	err = setOutputOwnership(filename, opts.fileMode, opts.gid)
+	fi, _ = os.Stat(filename)
+	if err != nil || fi.Mode().Perm() != 0640 {
+		t.Fatalf("unexpected file mode %v (%v)", fi.Mode(), err)
+	}
+
+	if err = createOutputDirectories(root, filepath.Join(root, "..", "escape"), opts); err == nil {
+		t.Fatal("expected a file outside the root to be refused")
+	}
+}
+
+func TestCreateOutputDirectoriesCreatesRoot(t *testing.T) {
+
+	// the missing root is created with the configured mode and group, its missing parent is not given them
+	parent := filepath.Join(t.TempDir(), "data")
+	root := filepath.Join(parent, "iiif")
+	opts := fsOutputOptions{dirMode: 0750 | os.ModeSetgid, gid: os.Getgid()}
+
+	err := createOutputDirectories(root, filepath.Join(root, "coll", "item01.jp2"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(parent)
+	if err != nil || fi.Mode().Perm() == 0750 || fi.Mode()&os.ModeSetgid != 0 {
+		t.Fatalf("unexpected parent directory %v (%v)", fi.Mode(), err)
+	}
+	for _, dir := range []string{root, filepath.Join(root, "coll")} {
+		fi, err := os.Stat(dir)
+		if err != nil || fi.Mode().Perm() != 0750 || fi.Mode()&os.ModeSetgid == 0 {
+			t.Fatalf("unexpected directory %s %v (%v)", dir, fi.Mode(), err)
+		}
+		if gid := fi.Sys().(*syscall.Stat_t).Gid; int(gid) != opts.gid {
+			t.Fatalf("unexpected group %d for %s", gid, dir)
+		}
+	}
+}
+
+func TestFileModeConversion(t *testing.T) {
+
+	tests := []struct {
+		unix uint32
+		mode os.FileMode
+	}{
+		{0750, 0750},
+		{02775, 0775 | os.ModeSetgid},
+		{01777, 0777 | os.ModeSticky},
+		{03770, 0770 | os.ModeSetgid | os.ModeSticky},
+	}
+	for _, tt := range tests {
+		if mode := unixToFileMode(tt.unix); mode != tt.mode {
+			t.Errorf("%#o: expected %v, got %v", tt.unix, tt.mode, mode)
+		}
+		if unix := fileModeToUnix(tt.mode); unix != tt.unix {
+			t.Errorf("%v: expected %#o, got %#o", tt.mode, tt.unix, unix)
+		}
+	}
+}
+
+func TestLookupGroup(t *testing.T) {
+
+	gid, err := lookupGroup(strconv.Itoa(os.Getgid()))
+	if err != nil || gid != os.Getgid() {
+		t.Fatalf("unexpected gid %d (%v)", gid, err)
+	}
+	if _, err = lookupGroup("no-such-group-for-iiif-ingest"); err == nil {
+		t.Fatal("expected an unknown group error")
+	}
+}
+
+//
+// end of file
+//
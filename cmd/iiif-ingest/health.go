@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how long readiness results are cached so frequent probes do not hammer SQS or the converter
+var readinessCacheTime = 15 * time.Second
+
+// how long the converter is given to report its version
+var converterCheckTimeout = 10 * time.Second
+
+// workerActivity tracks when each worker started its current job
+type workerActivity struct {
+	sync.Mutex
+	started map[int]time.Time
+}
+
+var activity = workerActivity{started: make(map[int]time.Time)}
+
+// note a worker has started a job
+func (wa *workerActivity) begin(workerId int) {
+	wa.Lock()
+	defer wa.Unlock()
+	wa.started[workerId] = time.Now()
+}
+
+// note a worker has finished a job
+func (wa *workerActivity) end(workerId int) {
+	wa.Lock()
+	defer wa.Unlock()
+	delete(wa.started, workerId)
+}
+
+// the number of workers that have been processing the same job for longer than the threshold
+func (wa *workerActivity) stuck(threshold time.Duration) int {
+	wa.Lock()
+	defer wa.Unlock()
+	count := 0
+	for _, started := range wa.started {
+		if time.Since(started) > threshold {
+			count++
+		}
+	}
+	return count
+}
+
+// healthChecker implements the liveness and readiness endpoints
+type healthChecker struct {
+	holder *configHolder
+	source MessageSource
+
+	sync.Mutex
+	lastChecked time.Time
+	lastResult  []string
+}
+
+func newHealthChecker(holder *configHolder, source MessageSource) *healthChecker {
+	return &healthChecker{holder: holder, source: source}
+}
+
+// the process is alive unless every worker is stuck
+func (hc *healthChecker) healthzHandler(w http.ResponseWriter, r *http.Request) {
+
+	config := hc.holder.Get()
+	threshold := time.Duration(config.StuckThreshold) * time.Second
+	stuck := activity.stuck(threshold)
+	if config.Workers > 0 && stuck >= config.Workers {
+		writeHealth(w, []string{fmt.Sprintf("all %d workers busy for more than %s", stuck, threshold)})
+		return
+	}
+	writeHealth(w, nil)
+}
+
+// we are ready if we can reach the queue, write to the work directory and run the converter
+func (hc *healthChecker) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, hc.readiness())
+}
+
+// the readiness problems, cached for a short time
+func (hc *healthChecker) readiness() []string {
+
+	hc.Lock()
+	defer hc.Unlock()
+
+	if time.Since(hc.lastChecked) < readinessCacheTime {
+		return hc.lastResult
+	}
+
+	config := hc.holder.Get()
+	var problems []string
+
+	err := hc.source.Ready()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("queue %s is not reachable (%s)", config.InQueueName, err.Error()))
+	}
+
+	err = checkWorkDir(config.LocalWorkDir, uint64(config.MinFreeSpace)*1024*1024)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	err = checkConverter(config.ConvertBinary, config.ConvertVersion)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	hc.lastChecked = time.Now()
+	hc.lastResult = problems
+	return problems
+}
+
+// ensure the work directory is writable and has the minimum free space
+func checkWorkDir(dir string, minFree uint64) error {
+
+	f, err := os.CreateTemp(dir, "healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("work directory %s is not writable (%s)", dir, err.Error())
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("cannot determine free space in %s (%s)", dir, err.Error())
+	}
+	if free < minFree {
+		return fmt.Errorf("work directory %s has %d bytes free, require %d", dir, free, minFree)
+	}
+	return nil
+}
+
+// ensure the converter can be run
+func checkConverter(binary string, versionOpt string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), converterCheckTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if len(versionOpt) != 0 {
+		cmd = exec.CommandContext(ctx, binary, versionOpt)
+	} else {
+		cmd = exec.CommandContext(ctx, binary)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("converter %s is not runnable (%s) [%s]", binary, err.Error(), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// the free space available to us in the specified directory (in bytes)
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+func writeHealth(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain")
+	if len(problems) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s\n", p)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "ok\n")
+}
+
+// the URL of one of our health endpoints based on the listen address
+func healthURL(listen string, endpoint string) string {
+	host := listen
+	if strings.HasPrefix(host, ":") == true {
+		host = "localhost" + host
+	}
+	return fmt.Sprintf("http://%s%s", host, endpoint)
+}
+
+//
+// end of file
+//
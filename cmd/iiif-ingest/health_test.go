@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readySource is a message source whose readiness can be changed, counting the checks made
+type readySource struct {
+	*memQueue
+	sync.Mutex
+	err    error
+	checks int
+}
+
+func (s *readySource) Ready() error {
+	s.Lock()
+	defer s.Unlock()
+	s.checks++
+	return s.err
+}
+
+func (s *readySource) setReady(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+// make a request to the handler, returning the status and body
+func healthRequest(handler http.HandlerFunc, endpoint string) (int, string) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, endpoint, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestHealthz(t *testing.T) {
+
+	cfg := ServiceConfig{Workers: 2, StuckThreshold: 60}
+	hc := newHealthChecker(newConfigHolder(cfg), &readySource{memQueue: newMemQueue()})
+
+	tests := []struct {
+		name    string
+		started []time.Duration // how long ago each worker started its job
+		status  int
+		body    string
+	}{
+		{"idle", nil, http.StatusOK, "ok"},
+		{"busy", []time.Duration{time.Second, time.Second}, http.StatusOK, "ok"},
+		{"one worker stuck", []time.Duration{time.Hour, time.Second}, http.StatusOK, "ok"},
+		{"every worker stuck", []time.Duration{time.Hour, time.Hour}, http.StatusServiceUnavailable, "all 2 workers busy"},
+	}
+	for _, tt := range tests {
+		for ix, ago := range tt.started {
+			activity.begin(100 + ix)
+			activity.Lock()
+			activity.started[100+ix] = time.Now().Add(-ago)
+			activity.Unlock()
+		}
+		status, body := healthRequest(hc.healthzHandler, "/healthz")
+		if status != tt.status || strings.Contains(body, tt.body) == false {
+			t.Errorf("%s: expected %d '%s', got %d '%s'", tt.name, tt.status, tt.body, status, body)
+		}
+		for ix := range tt.started {
+			activity.end(100 + ix)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+
+	cfg := ServiceConfig{InQueueName: "inbound", LocalWorkDir: t.TempDir(), ConvertBinary: "true"}
+	source := &readySource{memQueue: newMemQueue()}
+	hc := newHealthChecker(newConfigHolder(cfg), source)
+
+	status, body := healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusOK || body != "ok\n" {
+		t.Fatalf("expected ready, got %d '%s'", status, body)
+	}
+
+	// the failure is only seen once the cached result expires
+	source.setReady(errors.New("connection refused"))
+	status, _ = healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusOK || source.checks != 1 {
+		t.Fatalf("expected the cached result, got %d after %d checks", status, source.checks)
+	}
+	hc.lastChecked = time.Time{}
+	status, body = healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusServiceUnavailable || strings.Contains(body, "queue inbound is not reachable (connection refused)") == false {
+		t.Fatalf("expected not ready, got %d '%s'", status, body)
+	}
+
+	// and the failure is cached too, after the queue recovers
+	source.setReady(nil)
+	status, _ = healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusServiceUnavailable || source.checks != 2 {
+		t.Fatalf("expected the cached failure, got %d after %d checks", status, source.checks)
+	}
+	hc.lastChecked = time.Time{}
+	status, _ = healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusOK || source.checks != 3 {
+		t.Fatalf("expected ready, got %d after %d checks", status, source.checks)
+	}
+
+	// every problem is reported
+	hc.holder.set(ServiceConfig{InQueueName: "inbound", LocalWorkDir: "/nonexistent", ConvertBinary: "false"})
+	hc.lastChecked = time.Time{}
+	status, body = healthRequest(hc.readyzHandler, "/readyz")
+	if status != http.StatusServiceUnavailable || strings.Contains(body, "work directory /nonexistent is not writable") == false ||
+		strings.Contains(body, "converter false is not runnable") == false {
+		t.Fatalf("expected work directory and converter problems, got %d '%s'", status, body)
+	}
+}
+
+//
+// end of file
+//
@@ -1,108 +1,69 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path"
-	"regexp"
 	"strings"
 )
 
 func fatalIfError(err error) {
 	if err != nil {
-		log.Fatalf("FATAL ERROR: %s", err.Error())
+		slog.Error("FATAL ERROR", "error", err)
+		os.Exit(1)
 	}
 }
 
-// validate input file name against the set of valid input regex expressions
-func validateInputName(workerId int, config ServiceConfig, inputName string) error {
+// validate input file name against the set of routing rules and return the first rule that matches
+func validateInputName(logger *slog.Logger, config ServiceConfig, bucket string, inputName string) (*RoutingRule, error) {
 
-	log.Printf("[worker %d] DEBUG: validating input name '%s'", workerId, inputName)
+	logger.Debug("validating input name")
 
-	// remove the file suffix
-	fileExt := path.Ext(inputName)
-	name := strings.TrimSuffix(inputName, fileExt)
-
-	// match against the set of valid input regex expressions
-	matched := false
-	for ix, _ := range config.InputNameRegex {
-		matched, _ = regexp.MatchString(config.InputNameRegex[ix], name)
-		if matched == true {
-			break
+	// match against the set of routing rules
+	for ix := range config.Rules {
+		if config.Rules[ix].matches(bucket, inputName) == true {
+			logger.Debug("matched routing rule", "rule", config.Rules[ix].Name)
+			return &config.Rules[ix], nil
 		}
 	}
 
-	if matched == false {
-		return fmt.Errorf("input filename is invalid (%s)", name)
-	}
-
-	// all is well
-	return nil
+	// remove the file suffix
+	fileExt := path.Ext(inputName)
+	name := strings.TrimSuffix(inputName, fileExt)
+	return nil, fmt.Errorf("input filename is invalid (%s)", name)
 }
 
-// generate the output file name based on the input file and configuration
-func generateOutputName(workerId int, config ServiceConfig, inputName string) string {
-
-	log.Printf("[worker %d] DEBUG: generating output name for '%s'", workerId, inputName)
+// generate the output file name based on the input file and the matching routing rule
+func generateOutputName(logger *slog.Logger, rule *RoutingRule, inputName string) string {
 
 	// remove the file suffix
 	fileExt := path.Ext(inputName)
 	name := strings.TrimSuffix(inputName, fileExt)
 
-	for ix, _ := range config.InputNameRegex {
-
-		// we have already compiled it during the config phase so ignore the error return
-		re, _ := regexp.Compile(config.InputNameRegex[ix])
-		//log.Printf("[worker %d] DEBUG: matching [%s]", workerId, config.InputNameRegex[ix])
-		// does it match?
-		if re.MatchString(name) == true {
-			//log.Printf("[worker %d] DEBUG: matched, extracting substrings", workerId)
-			outputName := config.OutputNameTemplate[ix]
-			asm := re.FindAllStringSubmatch(name, -1)
-			for iy, sm := range asm[0] {
-				// ignore the 0 index as this is the full string match
-				if iy == 0 {
-					continue
-				}
-				//log.Printf("[worker %d] DEBUG: %d = %v", workerId, iy, sm)
-				placeholder := fmt.Sprintf("{:%d}", iy)
-				outputName = strings.Replace(outputName, placeholder, sm, -1)
-			}
-			outputName = fmt.Sprintf("%s.%s", outputName, config.ConvertSuffix)
-			log.Printf("[worker %d] DEBUG: generated output name [%s] -> [%s]", workerId, inputName, outputName)
-			return outputName
+	outputName := rule.OutputNameTemplate
+	asm := rule.re.FindAllStringSubmatch(name, -1)
+	for iy, sm := range asm[0] {
+		// ignore the 0 index as this is the full string match
+		if iy == 0 {
+			continue
 		}
+		placeholder := fmt.Sprintf("{:%d}", iy)
+		outputName = strings.Replace(outputName, placeholder, sm, -1)
 	}
-
-	log.Printf("[worker %d] ERROR: generating output name for %s", workerId, inputName)
-	// placeholder bogus value
-	return "unknown"
-}
-
-// create the output directory
-func createOutputDirectory(workerId int, outputName string) error {
-
-	// split into path and filename components
-	dirName := path.Dir(outputName)
-
-	log.Printf("[worker %d] DEBUG: creating directory %s", workerId, dirName)
-
-	// create the directory if appropriate
-	err := os.MkdirAll(dirName, 0755)
-	if err != nil {
-		log.Printf("[worker %d] ERROR: failed to create output directory %s (%s)", workerId, dirName, err.Error())
-		return err
-	}
-
-	return nil
+	outputName = fmt.Sprintf("%s.%s", outputName, rule.ConvertSuffix)
+	logger.Debug("generated output name", "output", outputName)
+	return outputName
 }
 
 // copy the file from the old location to the new one... we cannot use os.Rename as this only works withing a
 // single device
-func copyFile(workerId int, oldLocation, newLocation string) error {
+func copyFile(logger *slog.Logger, oldLocation, newLocation string) error {
 
-	log.Printf("[worker %d] INFO: copying %s to %s", workerId, oldLocation, newLocation)
+	logger.Info("copying file", "from", oldLocation, "to", newLocation)
 
 	i, err := os.Open(oldLocation)
 	if err != nil {
@@ -118,6 +79,23 @@ func copyFile(workerId int, oldLocation, newLocation string) error {
 	return err
 }
 
+// the SHA-256 checksum of a file in the form sha256:<hex>
+func fileChecksum(filename string) (string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(h.Sum(nil))), nil
+}
+
 //
 // end of file
 //
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// start the HTTP server for the metrics, health and job history endpoints. Should be run as a goroutine
+func httpServer(config ServiceConfig, health *healthChecker, jobs JobStore) {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health.healthzHandler)
+	mux.HandleFunc("/readyz", health.readyzHandler)
+	mux.HandleFunc("/jobs", jobsHandler(jobs))
+	mux.HandleFunc("/jobs/", jobsHandler(jobs))
+
+	slog.Info("HTTP server listening", "address", config.HttpListen)
+	err := http.ListenAndServe(config.HttpListen, mux)
+	fatalIfError(err)
+}
+
+// list jobs (GET /jobs?bucket=&prefix=&outcome=&limit=) or show a single job (GET /jobs/{id})
+func jobsHandler(jobs JobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		if len(id) != 0 {
+			job, err := jobs.Get(id)
+			if err != nil {
+				writeJobsError(w, err)
+				return
+			}
+			if job == nil {
+				http.Error(w, "job not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, job)
+			return
+		}
+
+		q := r.URL.Query()
+		filter := JobFilter{SourceBucket: q.Get("bucket"), KeyPrefix: q.Get("prefix"), Outcome: q.Get("outcome"), Limit: 100}
+		if len(q.Get("limit")) != 0 {
+			limit, err := strconv.Atoi(q.Get("limit"))
+			if err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		list, err := jobs.List(filter)
+		if err != nil {
+			writeJobsError(w, err)
+			return
+		}
+		writeJSON(w, list)
+	}
+}
+
+func writeJobsError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == ErrJobStoreDisabled {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+//
+// end of file
+//
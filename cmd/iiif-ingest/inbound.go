@@ -3,7 +3,7 @@ package main
 import (
 	"encoding/json"
 	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
-	"log"
+	"log/slog"
 	"net/url"
 	"time"
 )
@@ -12,16 +12,21 @@ type InboundFile struct {
 	SourceBucket string
 	SourceKey    string
 	ObjectSize   int64
+	ETag         string            // the object ETag
+	Attributes   map[string]string // the message attributes
+	ReceivedAt   time.Time         // when the message was received
 }
 
-func getInboundNotification(config ServiceConfig, aws awssqs.AWS_SQS, inQueueHandle awssqs.QueueHandle) (*InboundFile, awssqs.ReceiptHandle, error) {
+// get the next inbound files, receiving up to max messages at once and waiting until there is at least one.
+// A message that cannot be decoded will never be processed so it is logged and deleted
+func getInboundNotifications(config ServiceConfig, source MessageSource, acker MessageAcker, max uint) []Notify {
 
 	for {
 
-		// get the next message if one is available
-		messages, err := aws.BatchMessageGet(inQueueHandle, 1, time.Duration(config.PollTimeOut)*time.Second)
+		// get the next messages if any are available
+		messages, err := source.Receive(max, time.Duration(config.PollTimeOut)*time.Second)
 		if err != nil {
-			log.Printf("ERROR: during message get (%s), sleeping and retrying", err.Error())
+			slog.Error("message get failed, sleeping and retrying", "error", err)
 
 			// sleep for a while
 			time.Sleep(1 * time.Second)
@@ -31,50 +36,97 @@ func getInboundNotification(config ServiceConfig, aws awssqs.AWS_SQS, inQueueHan
 		}
 
 		// did we get anything to process
-		if len(messages) == 1 {
-
-			log.Printf("[main] INFO: received a new notification")
-
-			//log.Printf("%s", string( messages[0].Payload ) )
+		if len(messages) == 0 {
+			slog.Debug("no new notifications")
+			continue
+		}
 
-			// assume the message is an S3 event containing a list of one or more new objects
-			newS3objects, err := decodeS3Event(messages[0])
+		slog.Info("received new notifications", "count", len(messages))
+		notifies := make([]Notify, 0, len(messages))
+		for _, message := range messages {
+			inboundFile, err := decodeInboundFile(message)
 			if err != nil {
-				return nil, "", err
+				log := slog.With("receipt", string(message.ReceiptHandle))
+				log.Error("cannot decode notification, deleting it", "error", err, "payload", string(message.Payload))
+				messageCount("", outcomeRejected)
+				err = deleteMessage(log, acker, message.ReceiptHandle)
+				if err != nil {
+					log.Error("failed to delete an undecodable message", "error", err)
+				}
+				continue
+			}
+			if inboundFile == nil {
+				slog.Warn("not an interesting notification, ignoring it")
+				messageCount("", outcomeIgnored)
+				continue
 			}
+			messageCount(inboundFile.SourceKey, outcomeReceived)
+			notifies = append(notifies, inboundFile.notify(message.ReceiptHandle))
+		}
+		if len(notifies) != 0 {
+			return notifies
+		}
+	}
+}
 
-			// we have an object to download
-			if len(newS3objects) == 1 {
+// the inbound file announced by a message, nil if the message is not for a single new object
+func decodeInboundFile(message awssqs.Message) (*InboundFile, error) {
 
-				// some file names may be HTML encoded... un-encode them here...
-				key, err := url.QueryUnescape(newS3objects[0].S3.Object.Key)
-				if err != nil {
-					return nil, "", err
-				}
+	// assume the message is an S3 event containing a list of one or more new objects
+	newS3objects, err := decodeS3Event(message)
+	if err != nil {
+		return nil, err
+	}
 
-				inboundFile := InboundFile{
-					SourceBucket: newS3objects[0].S3.Bucket.Name,
-					SourceKey:    key,
-					ObjectSize:   newS3objects[0].S3.Object.Size}
+	// we have an object to download
+	if len(newS3objects) != 1 {
+		return nil, nil
+	}
 
-				return &inboundFile, messages[0].ReceiptHandle, nil
-			} else {
-				log.Printf("[main] WARNING: not an interesting notification, ignoring it")
-			}
+	// some file names may be HTML encoded... un-encode them here...
+	key, err := url.QueryUnescape(newS3objects[0].S3.Object.Key)
+	if err != nil {
+		return nil, err
+	}
 
-		} else {
-			log.Printf("[main] INFO: no new notifications...")
-		}
+	return &InboundFile{
+		SourceBucket: newS3objects[0].S3.Bucket.Name,
+		SourceKey:    key,
+		ObjectSize:   newS3objects[0].S3.Object.Size,
+		ETag:         newS3objects[0].S3.Object.ETag,
+		Attributes:   messageAttributes(message),
+		ReceivedAt:   time.Now()}, nil
+}
+
+// the worker notification for an inbound file
+func (f *InboundFile) notify(receiptHandle awssqs.ReceiptHandle) Notify {
+	return Notify{
+		SourceBucket:  f.SourceBucket,
+		BucketKey:     f.SourceKey,
+		ExpectedSize:  f.ObjectSize,
+		ETag:          f.ETag,
+		ReceiptHandle: receiptHandle,
+
+		MessageAttributes: f.Attributes,
+		ReceivedAt:        f.ReceivedAt,
 	}
 }
 
+// the S3 event for a new object, as S3 would send it
+func s3Event(bucket string, key string, size int64) Events {
+	return Events{Records: []S3EventRecord{{S3: S3Record{
+		Bucket: BucketRecord{Name: bucket},
+		Object: ObjectRecord{Key: url.QueryEscape(key), Size: size},
+	}}}}
+}
+
 // turn a message received from the inbound queue into a list of zero or more new S3 objects
 func decodeS3Event(message awssqs.Message) ([]S3EventRecord, error) {
 
 	events := Events{}
 	err := json.Unmarshal([]byte(message.Payload), &events)
 	if err != nil {
-		log.Printf("[main] ERROR: json unmarshal: %s", err)
+		slog.Error("json unmarshal failed", "error", err)
 		return nil, err
 	}
 	return events.Records, nil
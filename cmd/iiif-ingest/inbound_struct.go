@@ -22,6 +22,7 @@ type BucketRecord struct {
 type ObjectRecord struct {
 	Key  string `json:"key"`
 	Size int64  `json:"size"`
+	ETag string `json:"eTag"`
 }
 
 //
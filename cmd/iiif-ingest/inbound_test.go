@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInboundBatch(t *testing.T) {
+
+	cfg := ServiceConfig{PollTimeOut: 1}
+	queue := newMemQueue()
+	queue.send(`{"Records":[{"s3":{"bucket":{"name":"in"},"object":{"key":"coll/item01.tif","size":10}}}]}`)
+	queue.send(`{"Records":[]}`)
+	bad := queue.send(`not an S3 event`)
+	queue.send(`{"Records":[{"s3":{"bucket":{"name":"in"},"object":{"key":"coll/item+02.tif","size":20,"eTag":"abc"}}}]}`)
+	queue.send(`{"Records":[{"s3":{"bucket":{"name":"in"},"object":{"key":"coll/item03.tif","size":30}}}]}`)
+
+	// the uninteresting message is skipped, the undecodable one deleted and the batch is limited
+	notifies := getInboundNotifications(cfg, queue, queue, 4)
+	if queue.isAcked(bad) == false {
+		t.Fatal("expected the undecodable message to be deleted")
+	}
+	if len(notifies) != 2 || notifies[0].BucketKey != "coll/item01.tif" || notifies[1].BucketKey != "coll/item 02.tif" ||
+		notifies[1].ExpectedSize != 20 || notifies[1].ETag != "abc" {
+		t.Fatalf("unexpected notifications %+v", notifies)
+	}
+
+	notifies = getInboundNotifications(cfg, queue, queue, 4)
+	if len(notifies) != 1 || notifies[0].BucketKey != "coll/item03.tif" {
+		t.Fatalf("unexpected notifications %+v", notifies)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// job outcomes as recorded in the job store
+const (
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobDeferred  = "deferred" // the job did not start (e.g. no work directory space) and the message is redelivered
+	jobRetried   = "retried"  // the converter was killed and the message is redelivered
+)
+
+// errors that identify jobs that did not fail but will be attempted again
+var (
+	errJobDeferred = errors.New("job deferred")
+	errJobRetried  = errors.New("job will be retried")
+)
+
+// the job outcome for the error a job finished with
+func jobOutcome(err error) string {
+	switch {
+	case err == nil:
+		return jobCompleted
+	case errors.Is(err, errJobDeferred):
+		return jobDeferred
+	case errors.Is(err, errJobRetried):
+		return jobRetried
+	default:
+		return jobFailed
+	}
+}
+
+// JobRecord describes a single processing attempt of an inbound object
+type JobRecord struct {
+	Id              string    `json:"id"`
+	SourceBucket    string    `json:"source_bucket"`
+	SourceKey       string    `json:"source_key"`
+	SourceSize      int64     `json:"source_size"`
+	Rule            string    `json:"rule"`
+	Output          string    `json:"output"`
+	Options         string    `json:"options"`
+	OptionsHash     string    `json:"options_hash"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	DownloadSeconds float64   `json:"download_seconds"`
+	ConvertSeconds  float64   `json:"convert_seconds"`
+	UploadSeconds   float64   `json:"upload_seconds"`
+	Attempts        int       `json:"attempts"`
+	Outcome         string    `json:"outcome"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// JobFilter selects job records, empty values match everything
+type JobFilter struct {
+	SourceBucket string // the source bucket
+	KeyPrefix    string // the source key prefix
+	Outcome      string // the job outcome
+	Limit        int    // the maximum number of records, most recent first
+}
+
+// JobStore records the history of processed jobs
+type JobStore interface {
+
+	// Record a finished job; the store determines the attempt number
+	Record(job *JobRecord) error
+
+	// List the jobs matching the filter, most recent first
+	List(filter JobFilter) ([]JobRecord, error)
+
+	// Get a single job by id, nil if it does not exist
+	Get(id string) (*JobRecord, error)
+
+	// Close the store
+	Close() error
+}
+
+// ErrJobStoreDisabled is returned when querying jobs without a configured store
+var ErrJobStoreDisabled = fmt.Errorf("job store is not configured (IIIF_INGEST_JOB_STORE)")
+
+// create a job store from a specification of the form 'scheme://location', e.g. 'sqlite:///data/jobs.db'.
+// An empty specification disables job recording
+func newJobStore(spec string) (JobStore, error) {
+
+	if len(spec) == 0 {
+		return nullJobStore{}, nil
+	}
+
+	s := strings.SplitN(spec, "://", 2)
+	if len(s) != 2 {
+		return nil, fmt.Errorf("job store '%s' must be of the form scheme://location", spec)
+	}
+
+	switch s[0] {
+	case "sqlite":
+		return newSqliteJobStore(s[1])
+	default:
+		return nil, fmt.Errorf("unsupported job store type '%s'", s[0])
+	}
+}
+
+// a hash of the conversion settings so jobs converted the same way can be identified
+func optionsHash(binary string, options string, suffix string) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s", binary, options, suffix)))
+	return hex.EncodeToString(h[:8])
+}
+
+// nullJobStore is used when job recording is disabled
+type nullJobStore struct{}
+
+func (nullJobStore) Record(job *JobRecord) error                { return nil }
+func (nullJobStore) List(filter JobFilter) ([]JobRecord, error) { return nil, ErrJobStoreDisabled }
+func (nullJobStore) Get(id string) (*JobRecord, error)          { return nil, ErrJobStoreDisabled }
+func (nullJobStore) Close() error                               { return nil }
+
+//
+// end of file
+//
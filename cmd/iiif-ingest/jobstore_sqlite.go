@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// the job store schema
+var sqliteJobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id               TEXT PRIMARY KEY,
+	source_bucket    TEXT NOT NULL,
+	source_key       TEXT NOT NULL,
+	source_size      INTEGER NOT NULL,
+	rule             TEXT NOT NULL,
+	output           TEXT NOT NULL,
+	options          TEXT NOT NULL,
+	options_hash     TEXT NOT NULL,
+	started          INTEGER NOT NULL,
+	finished         INTEGER NOT NULL,
+	download_seconds REAL NOT NULL,
+	convert_seconds  REAL NOT NULL,
+	upload_seconds   REAL NOT NULL,
+	attempts         INTEGER NOT NULL,
+	outcome          TEXT NOT NULL,
+	error            TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_source ON jobs (source_bucket, source_key);
+CREATE INDEX IF NOT EXISTS jobs_started ON jobs (started);
+`
+
+var sqliteJobColumns = `id, source_bucket, source_key, source_size, rule, output, options, options_hash, started, finished,
+	download_seconds, convert_seconds, upload_seconds, attempts, outcome, error`
+
+// sqliteJobStore is a job store in a local SQLite database
+type sqliteJobStore struct {
+	db *sql.DB
+}
+
+func newSqliteJobStore(filename string) (JobStore, error) {
+
+	// WAL mode allows the CLI to query while the service is writing
+	db, err := sql.Open("sqlite", filename+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqliteJobSchema)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &sqliteJobStore{db: db}, nil
+}
+
+func (s *sqliteJobStore) Record(job *JobRecord) error {
+
+	// the attempt number is one more than the number of previous jobs for the same object; it is determined
+	// by the insert itself so concurrent jobs for the same object get distinct attempt numbers
+	return s.db.QueryRow("INSERT INTO jobs ("+sqliteJobColumns+") SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+
+		"(SELECT COUNT(*) + 1 FROM jobs WHERE source_bucket = ? AND source_key = ?), ?, ? RETURNING attempts",
+		job.Id, job.SourceBucket, job.SourceKey, job.SourceSize, job.Rule, job.Output, job.Options, job.OptionsHash,
+		job.Started.UnixMilli(), job.Finished.UnixMilli(), job.DownloadSeconds, job.ConvertSeconds, job.UploadSeconds,
+		job.SourceBucket, job.SourceKey, job.Outcome, job.Error).Scan(&job.Attempts)
+}
+
+func (s *sqliteJobStore) List(filter JobFilter) ([]JobRecord, error) {
+
+	query := "SELECT " + sqliteJobColumns + " FROM jobs WHERE 1 = 1"
+	var args []interface{}
+	if len(filter.SourceBucket) != 0 {
+		query += " AND source_bucket = ?"
+		args = append(args, filter.SourceBucket)
+	}
+	if len(filter.KeyPrefix) != 0 {
+		query += " AND substr(source_key, 1, ?) = ?"
+		args = append(args, len(filter.KeyPrefix), filter.KeyPrefix)
+	}
+	if len(filter.Outcome) != 0 {
+		query += " AND outcome = ?"
+		args = append(args, filter.Outcome)
+	}
+	query += " ORDER BY started DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]JobRecord, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqliteJobStore) Get(id string) (*JobRecord, error) {
+
+	rows, err := s.db.Query("SELECT "+sqliteJobColumns+" FROM jobs WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() == false {
+		return nil, rows.Err()
+	}
+	return scanJob(rows)
+}
+
+func (s *sqliteJobStore) Close() error {
+	return s.db.Close()
+}
+
+func scanJob(rows *sql.Rows) (*JobRecord, error) {
+	var job JobRecord
+	var started, finished int64
+	err := rows.Scan(&job.Id, &job.SourceBucket, &job.SourceKey, &job.SourceSize, &job.Rule, &job.Output, &job.Options,
+		&job.OptionsHash, &started, &finished, &job.DownloadSeconds, &job.ConvertSeconds, &job.UploadSeconds,
+		&job.Attempts, &job.Outcome, &job.Error)
+	if err != nil {
+		return nil, err
+	}
+	job.Started = time.UnixMilli(started).UTC()
+	job.Finished = time.UnixMilli(finished).UTC()
+	return &job, nil
+}
+
+//
+// end of file
+//
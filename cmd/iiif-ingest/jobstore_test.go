@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJobOutcome(t *testing.T) {
+
+	tests := []struct {
+		err     error
+		outcome string
+	}{
+		{nil, jobCompleted},
+		{errors.New("conversion failed"), jobFailed},
+		{fmt.Errorf("%w: insufficient work directory space", errJobDeferred), jobDeferred},
+		{fmt.Errorf("%w: signal: killed", errJobRetried), jobRetried},
+	}
+	for _, tt := range tests {
+		if outcome := jobOutcome(tt.err); outcome != tt.outcome {
+			t.Errorf("%v: expected %s, got %s", tt.err, tt.outcome, outcome)
+		}
+	}
+}
+
+func TestSqliteJobStoreConcurrentAttempts(t *testing.T) {
+
+	store, err := newJobStore("sqlite://" + filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// concurrent jobs for the same object each get their own attempt number
+	const jobs = 8
+	attempts := make([]int, jobs)
+	var wg sync.WaitGroup
+	for ix := 0; ix < jobs; ix++ {
+		wg.Add(1)
+		go func(ix int) {
+			defer wg.Done()
+			job := JobRecord{Id: fmt.Sprintf("job-%d", ix), SourceBucket: "in", SourceKey: "coll/item.tif",
+				Started: time.Now(), Finished: time.Now(), Outcome: jobRetried}
+			err := store.Record(&job)
+			if err != nil {
+				t.Error(err)
+			}
+			attempts[ix] = job.Attempts
+		}(ix)
+	}
+	wg.Wait()
+
+	sort.Ints(attempts)
+	for ix, a := range attempts {
+		if a != ix+1 {
+			t.Fatalf("expected attempts 1 to %d, got %v", jobs, attempts)
+		}
+	}
+
+	// the stored attempt is the one returned
+	job, err := store.Get("job-0")
+	if err != nil || job == nil || job.Attempts < 1 || job.Outcome != jobRetried {
+		t.Fatalf("unexpected job %+v (%v)", job, err)
+	}
+}
+
+//
+// end of file
+//
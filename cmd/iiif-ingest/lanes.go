@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// the name of the lane used when none are configured
+var defaultLaneName = "default"
+
+var laneBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "iiif_ingest_lane_backlog",
+	Help: "The number of messages waiting for a worker by lane",
+}, []string{"lane"})
+
+// Lane is a pool of workers for jobs up to a maximum expected size, so large masters cannot occupy every
+// worker while small ones wait
+type Lane struct {
+	Name    string // the lane name
+	MaxSize int64  // the largest expected size handled by the lane (in MB, 0 for no limit)
+	Workers int    // the number of workers in the lane
+}
+
+func (l Lane) String() string {
+	if l.MaxSize == 0 {
+		return fmt.Sprintf("%s: any size, %d worker(s)", l.Name, l.Workers)
+	}
+	return fmt.Sprintf("%s: up to %d MB, %d worker(s)", l.Name, l.MaxSize, l.Workers)
+}
+
+// does the lane handle jobs of the specified size (in bytes)
+func (l Lane) accepts(size int64) bool {
+	return l.MaxSize == 0 || size <= l.MaxSize*1024*1024
+}
+
+// parse a lane definition of the form name:max-size-mb:workers, an empty maximum size means no limit
+func parseLane(spec string) (Lane, error) {
+
+	s := strings.Split(spec, ":")
+	if len(s) != 3 {
+		return Lane{}, fmt.Errorf("expected name:max-size:workers")
+	}
+	lane := Lane{Name: strings.TrimSpace(s[0])}
+	if len(lane.Name) == 0 {
+		return Lane{}, fmt.Errorf("lane name is empty")
+	}
+	var err error
+	if len(strings.TrimSpace(s[1])) != 0 {
+		lane.MaxSize, err = strconv.ParseInt(strings.TrimSpace(s[1]), 10, 64)
+		if err != nil || lane.MaxSize <= 0 {
+			return Lane{}, fmt.Errorf("invalid maximum size '%s'", s[1])
+		}
+	}
+	lane.Workers, err = strconv.Atoi(strings.TrimSpace(s[2]))
+	if err != nil || lane.Workers <= 0 {
+		return Lane{}, fmt.Errorf("invalid worker count '%s'", s[2])
+	}
+	return lane, nil
+}
+
+// ensure the lanes are ordered by increasing size with a final lane without a limit, and account for every worker
+func validateLanes(lanes []Lane, workers int) error {
+
+	total := 0
+	names := make(map[string]bool)
+	for ix, l := range lanes {
+		if len(l.Name) == 0 || l.Workers <= 0 || l.MaxSize < 0 {
+			return fmt.Errorf("lane %d must have a name, a positive worker count and a non-negative maximum size", ix+1)
+		}
+		if names[l.Name] == true {
+			return fmt.Errorf("duplicate lane '%s'", l.Name)
+		}
+		names[l.Name] = true
+		last := ix == len(lanes)-1
+		if last == true && l.MaxSize != 0 {
+			return fmt.Errorf("the last lane '%s' must not have a maximum size", l.Name)
+		}
+		if last == false && l.MaxSize == 0 {
+			return fmt.Errorf("only the last lane can be without a maximum size, not '%s'", l.Name)
+		}
+		if ix != 0 && last == false && l.MaxSize <= lanes[ix-1].MaxSize {
+			return fmt.Errorf("lane '%s' must have a larger maximum size than lane '%s'", l.Name, lanes[ix-1].Name)
+		}
+		total += l.Workers
+	}
+	if total != workers {
+		return fmt.Errorf("lane workers total %d, expected %d", total, workers)
+	}
+	return nil
+}
+
+// the configured lanes, or a single lane for every worker
+func (cfg ServiceConfig) workerLanes() []Lane {
+	if len(cfg.Lanes) != 0 {
+		return cfg.Lanes
+	}
+	return []Lane{{Name: defaultLaneName, Workers: cfg.Workers}}
+}
+
+// laneScheduler routes notifications to the lane for their expected size. Each lane has a backlog of up to
+// capacity notifications, a notification for a full lane is held in that lane's overflow rather than blocking
+// submission so the other lanes keep receiving work. The receive loop bounds the overflow by never receiving
+// more than the total room left in the backlogs
+type laneScheduler struct {
+	sync.Mutex
+	cond     *sync.Cond
+	lanes    []Lane
+	backlog  [][]Notify // the waiting notifications by lane (including any overflow)
+	handing  []int      // the notifications taken from a backlog but not yet given to a worker by lane
+	capacity int        // the maximum waiting notifications per lane before it overflows
+}
+
+func newLaneScheduler(lanes []Lane, capacity int) *laneScheduler {
+	s := &laneScheduler{lanes: lanes, backlog: make([][]Notify, len(lanes)), handing: make([]int, len(lanes)),
+		capacity: max(capacity, 1)}
+	s.cond = sync.NewCond(&s.Mutex)
+	for _, l := range lanes {
+		laneBacklog.WithLabelValues(l.Name).Set(0)
+	}
+	return s
+}
+
+// the lane for a notification, the first that accepts its expected size
+func (s *laneScheduler) laneFor(notify Notify) int {
+	for ix, l := range s.lanes {
+		if l.accepts(notify.ExpectedSize) == true {
+			return ix
+		}
+	}
+	return len(s.lanes) - 1
+}
+
+// queue a notification for its lane, a full lane overflows rather than blocking. Returns the lane name
+func (s *laneScheduler) submit(notify Notify) string {
+
+	s.Lock()
+	defer s.Unlock()
+	ix := s.laneFor(notify)
+	s.backlog[ix] = append(s.backlog[ix], notify)
+	laneBacklog.WithLabelValues(s.lanes[ix].Name).Set(float64(len(s.backlog[ix])))
+	s.cond.Broadcast()
+	return s.lanes[ix].Name
+}
+
+// the number of notifications worth receiving given the workers able to start a job in each lane (idle):
+// no more than those workers will not already get from the backlogs, and no more than the total room left
+// in the backlogs so overflow is bounded
+func (s *laneScheduler) receiveSize(idle []int) int {
+
+	s.Lock()
+	defer s.Unlock()
+	wanted := 0
+	room := s.capacity * len(s.lanes)
+	for ix := range s.lanes {
+		waiting := len(s.backlog[ix]) + s.handing[ix]
+		wanted += max(idle[ix]-waiting, 0)
+		room -= waiting
+	}
+	return max(min(wanted, room), 0)
+}
+
+// the next notification for a lane, blocking until there is one
+func (s *laneScheduler) next(ix int) Notify {
+
+	s.Lock()
+	defer s.Unlock()
+	for len(s.backlog[ix]) == 0 {
+		s.cond.Wait()
+	}
+	notify := s.backlog[ix][0]
+	s.backlog[ix] = s.backlog[ix][1:]
+	s.handing[ix]++
+	laneBacklog.WithLabelValues(s.lanes[ix].Name).Set(float64(len(s.backlog[ix])))
+	s.cond.Broadcast()
+	return notify
+}
+
+// the waiting notifications of a lane
+func (s *laneScheduler) backlogOf(ix int) int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.backlog[ix])
+}
+
+// the total waiting notifications
+func (s *laneScheduler) depth() int {
+	s.Lock()
+	defer s.Unlock()
+	depth := 0
+	for _, b := range s.backlog {
+		depth += len(b)
+	}
+	return depth
+}
+
+// note a notification taken from a lane's backlog has been given to a worker
+func (s *laneScheduler) handed(ix int) {
+	s.Lock()
+	defer s.Unlock()
+	s.handing[ix]--
+}
+
+// the channel feeding the workers of a lane
+func (s *laneScheduler) notifies(ix int) <-chan Notify {
+	ch := make(chan Notify)
+	go func() {
+		for {
+			ch <- s.next(ix)
+			s.handed(ix)
+		}
+	}()
+	return ch
+}
+
+// how long to wait before checking again when no worker can take more notifications
+var receiveWait = 250 * time.Millisecond
+
+// receive and schedule as many notifications as the workers are ready for. None are received while every
+// worker able to start a job already has one waiting, so messages are not held invisible behind long
+// conversions
+func scheduleNotifications(config ServiceConfig, source MessageSource, acker MessageAcker, scheduler *laneScheduler, pool *workerPool) {
+
+	size := min(scheduler.receiveSize(pool.idle()), config.ReceiveBatch)
+	if size <= 0 {
+		time.Sleep(receiveWait)
+		return
+	}
+
+	// schedule each for a worker in its lane
+	notifies := getInboundNotifications(config, source, acker, uint(size))
+	for _, notify := range notifies {
+		lane := scheduler.submit(notify)
+		slog.Debug("message scheduled", "lane", lane, "bucket", notify.SourceBucket, "key", notify.BucketKey)
+	}
+}
+
+//
+// end of file
+//
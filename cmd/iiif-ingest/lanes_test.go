@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseLane(t *testing.T) {
+
+	lane, err := parseLane("small:100:6")
+	if err != nil || lane != (Lane{Name: "small", MaxSize: 100, Workers: 6}) {
+		t.Fatalf("unexpected lane %+v (%v)", lane, err)
+	}
+	lane, err = parseLane("large::2")
+	if err != nil || lane != (Lane{Name: "large", Workers: 2}) {
+		t.Fatalf("unexpected lane %+v (%v)", lane, err)
+	}
+	for _, spec := range []string{"small:100", ":100:6", "small:-1:6", "small:100:0", "small:big:6"} {
+		_, err = parseLane(spec)
+		if err == nil {
+			t.Fatalf("expected '%s' to be rejected", spec)
+		}
+	}
+}
+
+func TestValidateLanes(t *testing.T) {
+
+	good := []Lane{{Name: "small", MaxSize: 100, Workers: 6}, {Name: "medium", MaxSize: 500, Workers: 2}, {Name: "large", Workers: 2}}
+	if err := validateLanes(good, 10); err != nil {
+		t.Fatal(err)
+	}
+	bad := map[string][]Lane{
+		"worker total":    good,
+		"bounded last":    {{Name: "small", MaxSize: 100, Workers: 10}},
+		"unbounded first": {{Name: "large", Workers: 5}, {Name: "small", MaxSize: 100, Workers: 5}},
+		"out of order":    {{Name: "medium", MaxSize: 500, Workers: 4}, {Name: "small", MaxSize: 100, Workers: 4}, {Name: "large", Workers: 2}},
+		"duplicate":       {{Name: "small", MaxSize: 100, Workers: 5}, {Name: "small", Workers: 5}},
+	}
+	for name, lanes := range bad {
+		workers := 10
+		if name == "worker total" {
+			workers = 8
+		}
+		if err := validateLanes(lanes, workers); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestLaneSchedulerRoutesBySize(t *testing.T) {
+
+	s := newLaneScheduler([]Lane{{Name: "small", MaxSize: 1, Workers: 1}, {Name: "large", Workers: 1}}, 2)
+
+	for _, n := range []Notify{{BucketKey: "a", ExpectedSize: 1024}, {BucketKey: "b", ExpectedSize: 2 * 1024 * 1024}, {BucketKey: "c"}} {
+		s.submit(n)
+	}
+	if n := s.next(0); n.BucketKey != "a" {
+		t.Fatalf("unexpected small lane notification %s", n.BucketKey)
+	}
+	if n := s.next(0); n.BucketKey != "c" {
+		t.Fatalf("unexpected small lane notification %s", n.BucketKey)
+	}
+	if n := s.next(1); n.BucketKey != "b" {
+		t.Fatalf("unexpected large lane notification %s", n.BucketKey)
+	}
+	if s.depth() != 0 {
+		t.Fatalf("expected an empty backlog, got %d", s.depth())
+	}
+}
+
+func TestLaneSchedulerFullLane(t *testing.T) {
+
+	s := newLaneScheduler([]Lane{{Name: "small", MaxSize: 1, Workers: 1}, {Name: "large", Workers: 1}}, 1)
+	large := Notify{BucketKey: "large", ExpectedSize: 1 << 30}
+	small := Notify{BucketKey: "small", ExpectedSize: 1}
+
+	// a full large lane overflows rather than blocking, and does not hold up the small one
+	done := make(chan bool)
+	go func() {
+		s.submit(large)
+		s.submit(large)
+		s.submit(small)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected submission to a full lane not to block")
+	}
+	if n := s.next(0); n.BucketKey != "small" {
+		t.Fatalf("unexpected small lane notification %s", n.BucketKey)
+	}
+	if s.backlogOf(1) != 2 {
+		t.Fatalf("expected 2 large notifications waiting, got %d", s.backlogOf(1))
+	}
+}
+
+func TestLaneSchedulerReceiveSize(t *testing.T) {
+
+	lanes := []Lane{{Name: "small", MaxSize: 1, Workers: 2}, {Name: "large", Workers: 2}}
+	tests := []struct {
+		name   string
+		idle   []int
+		small  int // the small notifications waiting
+		large  int // the large notifications waiting
+		expect int
+	}{
+		{"every worker idle", []int{2, 2}, 0, 0, 4},
+		{"every worker busy", []int{0, 0}, 0, 0, 0},
+		{"idle workers already have work waiting", []int{2, 1}, 2, 1, 0},
+		{"a saturated large lane does not stop small jobs", []int{2, 0}, 0, 4, 2},
+		{"limited by the total room", []int{2, 0}, 0, 5, 1},
+		{"nothing while the backlogs are full", []int{2, 0}, 0, 6, 0},
+	}
+	for _, tt := range tests {
+		s := newLaneScheduler(lanes, 3)
+		for ix := 0; ix < tt.small; ix++ {
+			s.submit(Notify{ExpectedSize: 1})
+		}
+		for ix := 0; ix < tt.large; ix++ {
+			s.submit(Notify{ExpectedSize: 1 << 30})
+		}
+		if size := s.receiveSize(tt.idle); size != tt.expect {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expect, size)
+		}
+	}
+}
+
+func TestScheduleNotificationsWithSaturatedLane(t *testing.T) {
+
+	lanes := []Lane{{Name: "small", MaxSize: 1, Workers: 1}, {Name: "large", Workers: 1}}
+	s := newLaneScheduler(lanes, 2)
+	p := newWorkerPool(lanes, s.backlogOf)
+	cfg := ServiceConfig{PollTimeOut: 1, ReceiveBatch: 10}
+
+	// the large lane worker is busy with a long conversion and more large jobs are waiting
+	p.acquire(1)
+	p.begin(1)
+	s.submit(Notify{BucketKey: "large/waiting", ExpectedSize: 1 << 30})
+
+	queue := newMemQueue()
+	event := `{"Records":[{"s3":{"bucket":{"name":"in"},"object":{"key":"%s","size":%d}}}]}`
+	queue.send(fmt.Sprintf(event, "large/01.tif", 1<<30))
+	for ix := 1; ix <= 3; ix++ {
+		queue.send(fmt.Sprintf(event, fmt.Sprintf("small/%02d.tif", ix), 1024))
+	}
+
+	// small jobs keep being received and scheduled as the small lane worker gets through them
+	scheduled := make([]string, 0)
+	for len(scheduled) < 3 {
+		scheduleNotifications(cfg, queue, queue, s, p)
+		if s.backlogOf(0) == 0 {
+			continue
+		}
+		scheduled = append(scheduled, s.next(0).BucketKey)
+		s.handed(0)
+	}
+	if scheduled[0] != "small/01.tif" || scheduled[2] != "small/03.tif" {
+		t.Fatalf("unexpected small jobs %v", scheduled)
+	}
+	if s.backlogOf(1) != 2 {
+		t.Fatalf("expected 2 large notifications waiting, got %d", s.backlogOf(1))
+	}
+}
+
+//
+// end of file
+//
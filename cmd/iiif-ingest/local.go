@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// the local backends allow the full pipeline to run without AWS, intended for development
+
+// the scheme used to select a local backend
+var localScheme = "file://"
+
+// fsObjectStore is an ObjectStore that maps bucket/key to root/bucket/key on a local filesystem. Tags are kept
+// in a JSON file below root/.tags, any checksums are verified and the metadata, headers and storage class are
+// ignored
+type fsObjectStore struct {
+	root string
+	sync.Mutex
+}
+
+func newFSObjectStore(root string) (*fsObjectStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &fsObjectStore{root: root}, nil
+}
+
+// the filename for an object, the key cannot escape the bucket directory
+func (s *fsObjectStore) filename(bucket string, key string) (string, error) {
+	if len(bucket) == 0 || strings.ContainsAny(bucket, "/\\") == true || strings.HasPrefix(bucket, ".") == true {
+		return "", fmt.Errorf("invalid bucket name '%s'", bucket)
+	}
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid key '%s'", key)
+	}
+	return filepath.Join(s.root, bucket, filepath.FromSlash(clean)), nil
+}
+
+func (s *fsObjectStore) StatObject(bucket string, key string) (int64, error) {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *fsObjectStore) GetToFile(bucket string, key string, filename string) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	return copyLocalFile(name, filename)
+}
+
+func (s *fsObjectStore) GetReader(bucket string, key string) (io.ReadCloser, error) {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (s *fsObjectStore) PutFromFile(bucket string, key string, filename string, opts PutOptions) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = writeLocalFileChecked(name, in, opts)
+	if err != nil {
+		return err
+	}
+	return s.setTags(name, opts.Tags)
+}
+
+func (s *fsObjectStore) PutFromReader(bucket string, key string, r io.Reader, opts PutOptions) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = writeLocalFileChecked(name, r, opts)
+	if err != nil {
+		return err
+	}
+	return s.setTags(name, opts.Tags)
+}
+
+func (s *fsObjectStore) PutFromBuffer(bucket string, key string, buf []byte) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	return writeLocalFile(name, buf)
+}
+
+func (s *fsObjectStore) DeleteObject(bucket string, key string) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	// as with S3, deleting an object that does not exist is not an error
+	err = os.Remove(name)
+	if err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	_ = os.Remove(s.tagsFilename(name))
+	return nil
+}
+
+func (s *fsObjectStore) CopyObject(srcBucket string, srcKey string, dstBucket string, dstKey string, storageClass string) error {
+	src, err := s.filename(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := s.filename(dstBucket, dstKey)
+	if err != nil {
+		return err
+	}
+	return copyLocalFile(src, dst)
+}
+
+func (s *fsObjectStore) TagObject(bucket string, key string, tagKey string, tagValue string) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	tags := make(map[string]string)
+	tagsFile := s.tagsFilename(name)
+	buf, err := os.ReadFile(tagsFile)
+	if err == nil {
+		_ = json.Unmarshal(buf, &tags)
+	}
+	tags[tagKey] = tagValue
+	buf, err = json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return writeLocalFile(tagsFile, buf)
+}
+
+// there is no metadata to replace, the checksums are verified against the file
+func (s *fsObjectStore) UpdateMetadata(bucket string, key string, opts PutOptions) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sums := newChecksumWriter(len(opts.MD5) != 0)
+	_, err = io.Copy(sums, f)
+	if err != nil {
+		return err
+	}
+	return sums.verify(opts)
+}
+
+// replace the tags of a newly written object, as an S3 upload does
+func (s *fsObjectStore) setTags(name string, tags map[string]string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	tagsFile := s.tagsFilename(name)
+	if len(tags) == 0 {
+		err := os.Remove(tagsFile)
+		if err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+		return nil
+	}
+	buf, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return writeLocalFile(tagsFile, buf)
+}
+
+// the tags for an object are kept outside the bucket directories
+func (s *fsObjectStore) tagsFilename(name string) string {
+	rel, _ := filepath.Rel(s.root, name)
+	return filepath.Join(s.root, ".tags", rel+".json")
+}
+
+// copy a file, the destination is written atomically
+func copyLocalFile(from string, to string) error {
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeLocalFileFrom(to, in)
+}
+
+// write a file atomically, creating the directory as required
+func writeLocalFile(name string, buf []byte) error {
+	return writeLocalFileFrom(name, bytes.NewReader(buf))
+}
+
+func writeLocalFileFrom(name string, r io.Reader) error {
+	return writeLocalFileChecked(name, r, PutOptions{})
+}
+
+// write a file atomically, it is not written if it does not match the expected checksums
+func writeLocalFileChecked(name string, r io.Reader, opts PutOptions) error {
+
+	err := os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	sums := newChecksumWriter(len(opts.MD5) != 0)
+	_, err = io.Copy(io.MultiWriter(tmp, sums), r)
+	if err == nil {
+		err = sums.verify(opts)
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	cerr := tmp.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// dirQueue is a message queue held in a local directory, one JSON file per message. Received messages are
+// moved to the inflight subdirectory until acknowledged and are redelivered if not acknowledged in time.
+// Files that cannot be decoded are moved to the rejected subdirectory so they do not block the queue
+type dirQueue struct {
+	dir        string
+	visibility time.Duration // how long a received message is hidden before it is redelivered
+	sync.Mutex
+}
+
+// the contents of a message file
+type dirQueueMessage struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Body       string            `json:"body"`
+}
+
+func newDirQueue(dir string, visibility time.Duration) (*dirQueue, error) {
+
+	q := &dirQueue{dir: dir, visibility: visibility}
+	for _, d := range []string{q.inflight(), q.rejected()} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *dirQueue) inflight() string {
+	return filepath.Join(q.dir, "inflight")
+}
+
+func (q *dirQueue) rejected() string {
+	return filepath.Join(q.dir, "rejected")
+}
+
+func (q *dirQueue) Ready() error {
+	_, err := os.Stat(q.inflight())
+	return err
+}
+
+func (q *dirQueue) Receive(max uint, wait time.Duration) ([]awssqs.Message, error) {
+
+	deadline := time.Now().Add(wait)
+	for {
+		messages, err := q.receive(max)
+		if err != nil || len(messages) != 0 || time.Now().After(deadline) == true {
+			return messages, err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func (q *dirQueue) receive(max uint) ([]awssqs.Message, error) {
+
+	q.Lock()
+	defer q.Unlock()
+
+	// redeliver any messages that have not been acknowledged in time
+	inflight, err := os.ReadDir(q.inflight())
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range inflight {
+		fi, err := e.Info()
+		if err == nil && time.Since(fi.ModTime()) > q.visibility {
+			_ = os.Rename(filepath.Join(q.inflight(), e.Name()), filepath.Join(q.dir, e.Name()))
+		}
+	}
+
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// oldest first, the names begin with a timestamp
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() == true && strings.HasSuffix(e.Name(), ".json") == true {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	messages := make([]awssqs.Message, 0, max)
+	for _, name := range names {
+		if uint(len(messages)) == max {
+			break
+		}
+
+		// a file that cannot be read or decoded is set aside, the remaining messages are still delivered
+		var m dirQueueMessage
+		buf, err := os.ReadFile(filepath.Join(q.dir, name))
+		if err == nil {
+			err = json.Unmarshal(buf, &m)
+		}
+		if err != nil {
+			slog.Warn("rejecting undecodable message file", "queue", q.dir, "file", name, "error", err)
+			_ = os.Rename(filepath.Join(q.dir, name), filepath.Join(q.rejected(), name))
+			continue
+		}
+
+		// the modification time records when the message became inflight
+		to := filepath.Join(q.inflight(), name)
+		err = os.Rename(filepath.Join(q.dir, name), to)
+		if err != nil {
+			slog.Warn("cannot move message file inflight, skipping it", "queue", q.dir, "file", name, "error", err)
+			continue
+		}
+		now := time.Now()
+		_ = os.Chtimes(to, now, now)
+
+		attribs := make(awssqs.Attributes, 0, len(m.Attributes))
+		for k, v := range m.Attributes {
+			attribs = append(attribs, awssqs.Attribute{Name: k, Value: v})
+		}
+		messages = append(messages, awssqs.Message{Attribs: attribs, ReceiptHandle: awssqs.ReceiptHandle(name), Payload: []byte(m.Body)})
+	}
+	return messages, nil
+}
+
+func (q *dirQueue) Ack(receiptHandle awssqs.ReceiptHandle) error {
+	err := os.Remove(filepath.Join(q.inflight(), filepath.Base(string(receiptHandle))))
+	if err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	return nil
+}
+
+func (q *dirQueue) Publish(payload []byte, attributes map[string]string) error {
+
+	buf, err := json.Marshal(dirQueueMessage{Attributes: attributes, Body: string(payload)})
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.json", time.Now().UTC().Format("20060102T150405.000000000"), uuid.NewString())
+	return writeLocalFile(filepath.Join(q.dir, name), buf)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSObjectStore(t *testing.T) {
+
+	root := t.TempDir()
+	s, err := newFSObjectStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.PutFromBuffer("in", "coll/item01.tif", []byte("image data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := s.StatObject("in", "coll/item01.tif")
+	if err != nil || size != 10 {
+		t.Fatalf("unexpected stat %d (%v)", size, err)
+	}
+
+	err = s.CopyObject("in", "coll/item01.tif", "archive", "masters/coll/item01.tif", "GLACIER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "download")
+	err = s.GetToFile("archive", "masters/coll/item01.tif", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := os.ReadFile(filename)
+	if string(buf) != "image data" {
+		t.Fatalf("unexpected contents %q", buf)
+	}
+
+	_ = s.TagObject("in", "coll/item01.tif", "a", "1")
+	_ = s.TagObject("in", "coll/item01.tif", "b", "2")
+	buf, err = os.ReadFile(filepath.Join(root, ".tags", "in", "coll", "item01.tif.json"))
+	if err != nil || string(buf) != `{"a":"1","b":"2"}` {
+		t.Fatalf("unexpected tags %s (%v)", buf, err)
+	}
+
+	err = s.DeleteObject("in", "coll/item01.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.StatObject("in", "coll/item01.tif")
+	if os.IsNotExist(err) == false {
+		t.Fatalf("expected the object to be deleted (%v)", err)
+	}
+	err = s.DeleteObject("in", "coll/item01.tif")
+	if err != nil {
+		t.Fatalf("deleting a missing object should succeed (%v)", err)
+	}
+
+	// objects cannot be written outside the root
+	err = s.PutFromBuffer("..", "escape", []byte("x"))
+	if err == nil {
+		t.Fatal("expected an invalid bucket error")
+	}
+	_ = s.PutFromBuffer("in", "../../escape", []byte("x"))
+	_, err = os.Stat(filepath.Join(root, "in", "escape"))
+	if err != nil {
+		t.Fatalf("expected the key to be confined to the bucket (%v)", err)
+	}
+}
+
+func TestFSObjectStoreChecksums(t *testing.T) {
+
+	s, err := newFSObjectStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.PutFromReader("out", "coll/item01.jp2", strings.NewReader("image data"), PutOptions{SHA256: testDataSHA256, MD5: testDataMD5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a mismatch is not written
+	err = s.PutFromReader("out", "coll/item02.jp2", strings.NewReader("image data"), PutOptions{SHA256: strings.Repeat("0", 64)})
+	if err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+	_, err = s.StatObject("out", "coll/item02.jp2")
+	if os.IsNotExist(err) == false {
+		t.Fatalf("expected the object not to be written (%v)", err)
+	}
+}
+
+func TestDirQueue(t *testing.T) {
+
+	q, err := newDirQueue(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := q.Receive(10, 10*time.Millisecond)
+	if err != nil || len(messages) != 0 {
+		t.Fatalf("expected no messages, got %d (%v)", len(messages), err)
+	}
+
+	_ = q.Publish([]byte("first"), map[string]string{"traceparent": "x"})
+	_ = q.Publish([]byte("second"), nil)
+
+	messages, err = q.Receive(1, 0)
+	if err != nil || len(messages) != 1 || string(messages[0].Payload) != "first" || len(messages[0].Attribs) != 1 {
+		t.Fatalf("unexpected messages %+v (%v)", messages, err)
+	}
+	err = q.Ack(messages[0].ReceiptHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ = q.Receive(10, 0)
+	if len(messages) != 1 || string(messages[0].Payload) != "second" {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	// an unacknowledged message is redelivered once the visibility timeout expires
+	messages, _ = q.Receive(10, 0)
+	if len(messages) != 0 {
+		t.Fatal("expected the inflight message to be hidden")
+	}
+	q.visibility = 0
+	messages, _ = q.Receive(10, 0)
+	if len(messages) != 1 || string(messages[0].Payload) != "second" {
+		t.Fatalf("expected the message to be redelivered, got %+v", messages)
+	}
+}
+
+func TestDirQueueRejectsUndecodableFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := newDirQueue(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad file ahead of a good message does not block the queue
+	err = os.WriteFile(filepath.Join(dir, "0000-bad.json"), []byte("not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = q.Publish([]byte("good"), nil)
+
+	messages, err := q.Receive(10, 0)
+	if err != nil || len(messages) != 1 || string(messages[0].Payload) != "good" {
+		t.Fatalf("unexpected messages %+v (%v)", messages, err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "rejected", "0000-bad.json"))
+	if err != nil {
+		t.Fatalf("expected the bad file to be rejected (%v)", err)
+	}
+
+	messages, err = q.Receive(10, 0)
+	if err != nil || len(messages) != 0 {
+		t.Fatalf("expected no messages, got %+v (%v)", messages, err)
+	}
+}
+
+func TestLocalPipelineDeleteSource(t *testing.T) {
+
+	objects, err := newFSObjectStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := newDirQueue(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(auditFile, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(t, func(cfg *ServiceConfig) {
+		cfg.DeleteSource = true
+		cfg.SourceDisposition = dispositionDelete
+		cfg.AuditLog = auditFile
+	})
+	jobs := newMemJobStore()
+	notifies := make(chan Notify, 1)
+	svc := workerServices{objects: objects, acker: queue, jobs: jobs, audit: audit, events: &memEvents{},
+		pool: newWorkerPool([]Lane{{Name: "default", Workers: 1}}, nil), disk: newDiskBudget(freeSpace)}
+	go worker(1, 0, newConfigHolder(cfg), svc, notifies)
+
+	// as the submit command does
+	_ = objects.PutFromBuffer("in", "coll/item01.tif", []byte("image data"))
+	payload, _ := json.Marshal(s3Event("in", "coll/item01.tif", 10))
+	_ = queue.Publish(payload, nil)
+
+	inbound := getInboundNotifications(cfg, queue, queue, 1)
+	notifies <- inbound[0]
+
+	var job JobRecord
+	select {
+	case job = <-jobs.finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the job to finish")
+	}
+	if job.Outcome != jobCompleted {
+		t.Fatalf("expected job to complete, got %s (%s)", job.Outcome, job.Error)
+	}
+
+	size, err := objects.StatObject("out", "coll/item01.jp2")
+	if err != nil || size != 10 {
+		t.Fatalf("unexpected output %d (%v)", size, err)
+	}
+	_, err = objects.StatObject("in", "coll/item01.tif")
+	if os.IsNotExist(err) == false {
+		t.Fatalf("expected the source to be deleted (%v)", err)
+	}
+	entries, _ := os.ReadDir(queue.inflight())
+	if len(entries) != 0 {
+		t.Fatal("expected the message to be acknowledged")
+	}
+	buf, _ := os.ReadFile(auditFile)
+	if strings.Contains(string(buf), `"action":"deleted"`) == false {
+		t.Fatalf("unexpected audit log %s", buf)
+	}
+}
+
+//
+// end of file
+//
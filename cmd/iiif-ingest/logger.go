@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// the current log level, may be changed on configuration reload
+var logLevel = new(slog.LevelVar)
+
+// initialize logging; we use the environment (and for the format, the configuration file) directly as the
+// configuration load itself logs. Any configuration file error is reported by the configuration load
+func initLogging(out io.Writer) {
+
+	format, err := commandSetting("IIIF_INGEST_LOG_FORMAT", "")
+	if err != nil {
+		format = os.Getenv("IIIF_INGEST_LOG_FORMAT")
+	}
+	level, err := parseLogLevel(os.Getenv("IIIF_INGEST_LOG_LEVEL"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, using INFO\n", err.Error())
+	}
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if strings.ToLower(format) == "text" {
+		handler = slog.NewTextHandler(out, opts)
+	} else {
+		handler = slog.NewJSONHandler(out, opts)
+	}
+
+	// this also directs the standard logger (used by the AWS helper libraries) to our handler
+	slog.SetDefault(slog.New(handler))
+}
+
+// apply a (validated) log level
+func applyLogLevel(name string) {
+	level, _ := parseLogLevel(name)
+	logLevel.Set(level)
+}
+
+// parse a log level name, an empty name is INFO
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if len(name) == 0 {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level '%s'", name)
+	}
+	return level, nil
+}
+
+// the logger for a job, every line carries the job correlation attributes
+func newJobLogger(workerId int, jobId string, notify Notify) *slog.Logger {
+	return slog.With("worker", workerId, "job", jobId, "bucket", notify.SourceBucket, "key", notify.BucketKey)
+}
+
+//
+// end of file
+//
@@ -1,54 +1,88 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
-
-	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
-	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+	"time"
 )
 
 // main entry point
 func main() {
 
-	log.Printf("[main] ===> %s service staring up (version: %s) <===", os.Args[0], Version())
+	// handle any subcommands
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// setup logging before anything else
+	initLogging(os.Stderr)
+
+	slog.Info("service starting up", "service", os.Args[0], "version", Version())
 
 	// Get config params and use them to init service context. Any issues are fatal
 	cfg := LoadConfiguration()
+	applyLogLevel(cfg.LogLevel)
 
-	// load our AWS sqs helper object
-	aws, err := awssqs.NewAwsSqs(awssqs.AwsSqsConfig{MessageBucketName: " "})
+	// setup tracing if configured
+	err := initTracing(cfg.TraceExporter)
 	fatalIfError(err)
 
-	// load our AWS s3 helper object
-	s3Svc, err := uva_s3.NewUvaS3(uva_s3.UvaS3Config{Logging: true})
+	// the object store (S3, S3 compatible or local)
+	objects, err := openObjectStore(*cfg)
 	fatalIfError(err)
 
-	// get the queue handles from the queue name
-	inQueueHandle, err := aws.QueueHandle(cfg.InQueueName)
+	// the inbound queue (SQS or local)
+	inQueue, err := openQueue(cfg.InQueueName, time.Duration(cfg.LocalVisibility)*time.Second)
 	fatalIfError(err)
 
-	// create the notification channel
-	notifyChan := make(chan Notify, cfg.WorkerQueueSize)
+	// the configuration may be reloaded while we are running
+	holder := newConfigHolder(*cfg)
+	go configReloader(holder, time.Duration(cfg.ConfigPollInterval)*time.Second)
 
-	// start workers here
-	for w := 1; w <= cfg.Workers; w++ {
-		go worker(w, *cfg, aws, s3Svc, inQueueHandle, notifyChan)
+	// the job history store
+	jobs, err := newJobStore(cfg.JobStore)
+	fatalIfError(err)
+
+	// the audit log of source deletions
+	audit, err := newAuditLog(cfg.AuditLog, objects)
+	fatalIfError(err)
+
+	// the failure events
+	events, err := newEventEmitter(cfg.EventQueueName)
+	fatalIfError(err)
+
+	// the scheduler routing notifications to the worker lanes
+	lanes := cfg.workerLanes()
+	scheduler := newLaneScheduler(lanes, cfg.WorkerQueueSize)
+	registerQueueDepth(scheduler.depth)
+
+	// start the metrics and health endpoints
+	if len(cfg.HttpListen) != 0 {
+		go httpServer(*cfg, newHealthChecker(holder, inQueue), jobs)
 	}
 
-	for {
-		// notification that there is one or more new ingest files to be processed
-		inbound, receiptHandle, err := getInboundNotification(*cfg, aws, inQueueHandle)
-		fatalIfError(err)
-
-		// create the notification structure and send to the worker queue
-		notify := Notify{
-			SourceBucket:  inbound.SourceBucket,
-			BucketKey:     inbound.SourceKey,
-			ExpectedSize:  inbound.ObjectSize,
-			ReceiptHandle: receiptHandle,
+	// adapt the number of running workers to the resources available
+	pool := newWorkerPool(lanes, scheduler.backlogOf)
+	if cfg.AdaptiveWorkers == true {
+		go adaptWorkers(holder, pool)
+	}
+
+	// start workers here, each lane has its own
+	svc := workerServices{objects: objects, acker: inQueue, jobs: jobs, audit: audit, events: events,
+		pool: pool, disk: newDiskBudget(freeSpace)}
+	workerId := 1
+	for ix, lane := range lanes {
+		notifies := scheduler.notifies(ix)
+		for w := 0; w < lane.Workers; w++ {
+			go worker(workerId, ix, holder, svc, notifies)
+			workerId++
 		}
-		notifyChan <- notify
+		slog.Info("lane started", "lane", lane.String())
+	}
+
+	for {
+		// receive notifications and schedule them for the worker lanes
+		scheduleNotifications(holder.Get(), inQueue, inQueue, scheduler, pool)
 	}
 
 	// should never get here
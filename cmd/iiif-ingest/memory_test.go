@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// in-memory implementations of the pipeline services
+
+type memObject struct {
+	data         []byte
+	storageClass string
+	tags         map[string]string
+	opts         PutOptions
+}
+
+// memObjectStore is an ObjectStore held in memory
+type memObjectStore struct {
+	sync.Mutex
+	objects map[string]*memObject
+}
+
+func newMemObjectStore() *memObjectStore {
+	return &memObjectStore{objects: make(map[string]*memObject)}
+}
+
+func (m *memObjectStore) put(bucket string, key string, data []byte) {
+	m.putWithOptions(bucket, key, data, PutOptions{})
+}
+
+func (m *memObjectStore) putWithOptions(bucket string, key string, data []byte, opts PutOptions) {
+	m.Lock()
+	defer m.Unlock()
+	o := &memObject{data: data, storageClass: opts.StorageClass, tags: make(map[string]string), opts: opts}
+	for k, v := range opts.Tags {
+		o.tags[k] = v
+	}
+	m.objects[bucket+"/"+key] = o
+}
+
+func (m *memObjectStore) get(bucket string, key string) (*memObject, bool) {
+	m.Lock()
+	defer m.Unlock()
+	o, ok := m.objects[bucket+"/"+key]
+	return o, ok
+}
+
+func (m *memObjectStore) StatObject(bucket string, key string) (int64, error) {
+	o, ok := m.get(bucket, key)
+	if ok == false {
+		return 0, fmt.Errorf("no such object s3://%s/%s", bucket, key)
+	}
+	return int64(len(o.data)), nil
+}
+
+func (m *memObjectStore) GetToFile(bucket string, key string, filename string) error {
+	o, ok := m.get(bucket, key)
+	if ok == false {
+		return fmt.Errorf("no such object s3://%s/%s", bucket, key)
+	}
+	return os.WriteFile(filename, o.data, 0644)
+}
+
+func (m *memObjectStore) GetReader(bucket string, key string) (io.ReadCloser, error) {
+	o, ok := m.get(bucket, key)
+	if ok == false {
+		return nil, fmt.Errorf("no such object s3://%s/%s", bucket, key)
+	}
+	return io.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (m *memObjectStore) PutFromFile(bucket string, key string, filename string, opts PutOptions) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	m.putWithOptions(bucket, key, data, opts)
+	return nil
+}
+
+func (m *memObjectStore) PutFromReader(bucket string, key string, r io.Reader, opts PutOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.putWithOptions(bucket, key, data, opts)
+	return nil
+}
+
+func (m *memObjectStore) PutFromBuffer(bucket string, key string, buf []byte) error {
+	m.put(bucket, key, append([]byte(nil), buf...))
+	return nil
+}
+
+func (m *memObjectStore) DeleteObject(bucket string, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.objects, bucket+"/"+key)
+	return nil
+}
+
+func (m *memObjectStore) CopyObject(srcBucket string, srcKey string, dstBucket string, dstKey string, storageClass string) error {
+	o, ok := m.get(srcBucket, srcKey)
+	if ok == false {
+		return fmt.Errorf("no such object s3://%s/%s", srcBucket, srcKey)
+	}
+	m.put(dstBucket, dstKey, o.data)
+	c, _ := m.get(dstBucket, dstKey)
+	c.storageClass = storageClass
+	return nil
+}
+
+func (m *memObjectStore) TagObject(bucket string, key string, tagKey string, tagValue string) error {
+	m.Lock()
+	defer m.Unlock()
+	o, ok := m.objects[bucket+"/"+key]
+	if ok == false {
+		return fmt.Errorf("no such object s3://%s/%s", bucket, key)
+	}
+	o.tags[tagKey] = tagValue
+	return nil
+}
+
+func (m *memObjectStore) UpdateMetadata(bucket string, key string, opts PutOptions) error {
+	m.Lock()
+	defer m.Unlock()
+	o, ok := m.objects[bucket+"/"+key]
+	if ok == false {
+		return fmt.Errorf("no such object s3://%s/%s", bucket, key)
+	}
+	o.opts = opts
+	o.storageClass = opts.StorageClass
+	return nil
+}
+
+// memQueue is a MessageSource and MessageAcker held in memory
+type memQueue struct {
+	sync.Mutex
+	pending []awssqs.Message
+	acked   map[awssqs.ReceiptHandle]bool
+	next    int
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{acked: make(map[awssqs.ReceiptHandle]bool)}
+}
+
+func (q *memQueue) send(payload string) awssqs.ReceiptHandle {
+	q.Lock()
+	defer q.Unlock()
+	q.next++
+	rh := awssqs.ReceiptHandle(fmt.Sprintf("receipt-%d", q.next))
+	q.pending = append(q.pending, awssqs.Message{ReceiptHandle: rh, Payload: []byte(payload)})
+	return rh
+}
+
+func (q *memQueue) isAcked(rh awssqs.ReceiptHandle) bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.acked[rh]
+}
+
+func (q *memQueue) Receive(max uint, wait time.Duration) ([]awssqs.Message, error) {
+	q.Lock()
+	defer q.Unlock()
+	n := int(max)
+	if n > len(q.pending) {
+		n = len(q.pending)
+	}
+	messages := q.pending[:n]
+	q.pending = q.pending[n:]
+	return messages, nil
+}
+
+func (q *memQueue) Ready() error { return nil }
+
+func (q *memQueue) Ack(receiptHandle awssqs.ReceiptHandle) error {
+	q.Lock()
+	defer q.Unlock()
+	q.acked[receiptHandle] = true
+	return nil
+}
+
+// memJobStore is a JobStore held in memory, each recorded job is also sent to the finished channel
+type memJobStore struct {
+	sync.Mutex
+	jobs     []JobRecord
+	finished chan JobRecord
+}
+
+func newMemJobStore() *memJobStore {
+	return &memJobStore{finished: make(chan JobRecord, 16)}
+}
+
+func (s *memJobStore) Record(job *JobRecord) error {
+	s.Lock()
+	job.Attempts = 1
+	for _, j := range s.jobs {
+		if j.SourceBucket == job.SourceBucket && j.SourceKey == job.SourceKey {
+			job.Attempts++
+		}
+	}
+	s.jobs = append(s.jobs, *job)
+	s.Unlock()
+	s.finished <- *job
+	return nil
+}
+
+func (s *memJobStore) List(filter JobFilter) ([]JobRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	return append([]JobRecord(nil), s.jobs...), nil
+}
+
+func (s *memJobStore) Get(id string) (*JobRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, j := range s.jobs {
+		if j.Id == id {
+			return &j, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memJobStore) Close() error { return nil }
+
+// memAuditLog is an AuditLog held in memory
+type memAuditLog struct {
+	sync.Mutex
+	records []DeletionRecord
+}
+
+func (a *memAuditLog) Append(rec DeletionRecord) error {
+	a.Lock()
+	defer a.Unlock()
+	a.records = append(a.records, rec)
+	return nil
+}
+
+func (a *memAuditLog) all() []DeletionRecord {
+	a.Lock()
+	defer a.Unlock()
+	return append([]DeletionRecord(nil), a.records...)
+}
+
+// memEvents is an EventEmitter held in memory
+type memEvents struct {
+	sync.Mutex
+	events []Event
+}
+
+func (e *memEvents) Emit(ctx context.Context, ev Event) error {
+	e.Lock()
+	defer e.Unlock()
+	e.events = append(e.events, ev)
+	return nil
+}
+
+func (e *memEvents) all() []Event {
+	e.Lock()
+	defer e.Unlock()
+	return append([]Event(nil), e.events...)
+}
+
+//
+// end of file
+//
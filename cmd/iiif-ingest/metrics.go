@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// message outcomes
+const (
+	outcomeReceived  = "received"
+	outcomeIgnored   = "ignored"
+	outcomeRejected  = "rejected"
+	outcomeFailed    = "failed"
+	outcomeDeferred  = "deferred"
+	outcomeCompleted = "completed"
+	outcomeSuccess   = "success"
+	outcomeFailure   = "failure"
+)
+
+// histogram buckets for transfer and conversion durations (in seconds) and object sizes (in bytes)
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+var sizeBuckets = prometheus.ExponentialBuckets(64*1024, 4, 10) // 64KB to 16GB
+
+var messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "iiif_ingest_messages_total",
+	Help: "The number of inbound messages by input extension and outcome",
+}, []string{"extension", "outcome"})
+
+var downloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_download_duration_seconds",
+	Help:    "The time taken to download source objects",
+	Buckets: durationBuckets,
+}, []string{"extension", "outcome"})
+
+var conversionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_conversion_duration_seconds",
+	Help:    "The time taken to convert source objects",
+	Buckets: durationBuckets,
+}, []string{"extension", "outcome"})
+
+var uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_upload_duration_seconds",
+	Help:    "The time taken to write converted files to their destination",
+	Buckets: durationBuckets,
+}, []string{"extension", "outcome"})
+
+var downloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_download_bytes",
+	Help:    "The size of downloaded source objects",
+	Buckets: sizeBuckets,
+}, []string{"extension", "outcome"})
+
+var uploadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "iiif_ingest_upload_bytes",
+	Help:    "The size of converted files",
+	Buckets: sizeBuckets,
+}, []string{"extension", "outcome"})
+
+var workersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_workers_busy",
+	Help: "The number of workers currently processing a message",
+})
+
+// register the gauge reporting the number of messages waiting for a worker
+func registerQueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "iiif_ingest_worker_queue_depth",
+		Help: "The number of messages waiting for a worker",
+	}, func() float64 {
+		return float64(depth())
+	})
+}
+
+// the extension label for an object key
+func extensionLabel(key string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(key), "."))
+	if len(ext) == 0 {
+		return "none"
+	}
+	return ext
+}
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// count a message outcome
+func messageCount(key string, outcome string) {
+	messagesTotal.WithLabelValues(extensionLabel(key), outcome).Inc()
+}
+
+// record an object size
+func observeSize(histogram *prometheus.HistogramVec, key string, size int64, err error) {
+	histogram.WithLabelValues(extensionLabel(key), outcomeLabel(err)).Observe(float64(size))
+}
+
+// the size of a file, 0 if it cannot be determined
+func fileSize(filename string) int64 {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// stageTimer records the duration of a processing stage
+type stageTimer struct {
+	histogram *prometheus.HistogramVec
+	key       string
+	start     time.Time
+}
+
+func newStageTimer(histogram *prometheus.HistogramVec, key string) stageTimer {
+	return stageTimer{histogram: histogram, key: key, start: time.Now()}
+}
+
+// record and return the stage duration
+func (t stageTimer) observe(err error) time.Duration {
+	duration := time.Since(t.start)
+	t.histogram.WithLabelValues(extensionLabel(t.key), outcomeLabel(err)).Observe(duration.Seconds())
+	return duration
+}
+
+//
+// end of file
+//
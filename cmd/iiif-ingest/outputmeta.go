@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// the content types of the common output suffixes, others are looked up by extension
+var defaultContentTypes = map[string]string{
+	"jp2":  "image/jp2",
+	"jpx":  "image/jpx",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// S3 allows at most 10 tags on an object
+var maxOutputTags = 10
+
+// the placeholders available to output metadata and tag templates
+var outputPlaceholders = []string{"{job}", "{rule}", "{source_bucket}", "{source_key}", "{source_etag}", "{output}",
+	"{options_hash}", "{converter_version}"}
+
+var metadataNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+var templatePlaceholderRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+// ensure a metadata or tag template only uses the known placeholders
+func validateOutputTemplate(template string) error {
+	remaining := template
+	for _, p := range outputPlaceholders {
+		remaining = strings.ReplaceAll(remaining, p, "")
+	}
+	if unknown := templatePlaceholderRegex.FindString(remaining); len(unknown) != 0 {
+		return fmt.Errorf("unknown placeholder '%s' in '%s'", unknown, template)
+	}
+	return nil
+}
+
+// the content type for an output suffix, empty if unknown (the store default applies)
+func (cfg ServiceConfig) contentType(suffix string) string {
+	if t, ok := cfg.OutputContentTypes[suffix]; ok == true {
+		return t
+	}
+	if t, ok := defaultContentTypes[strings.ToLower(suffix)]; ok == true {
+		return t
+	}
+	return mime.TypeByExtension("." + suffix)
+}
+
+// the upload options for an output; the content type, cache control, storage class, templated metadata and tags
+// along with the checksums (when known)
+func outputPutOptions(config ServiceConfig, rule *RoutingRule, notify Notify, job *JobRecord, sums contentChecksums) PutOptions {
+
+	replacements := []string{
+		"{job}", job.Id,
+		"{rule}", job.Rule,
+		"{source_bucket}", notify.SourceBucket,
+		"{source_key}", notify.BucketKey,
+		"{source_etag}", notify.ETag,
+		"{output}", job.Output,
+		"{options_hash}", job.OptionsHash,
+	}
+	// only run the converter for its version when it is needed
+	if config.outputTemplatesUse("{converter_version}") == true {
+		replacements = append(replacements, "{converter_version}", converterVersion(config.ConvertBinary, config.ConvertVersion))
+	}
+	r := strings.NewReplacer(replacements...)
+
+	opts := sums.putOptions()
+	opts.ContentType = config.contentType(rule.ConvertSuffix)
+	opts.CacheControl = config.OutputCacheControl
+	opts.StorageClass = config.OutputStorageClass
+	for name, t := range config.OutputMetadata {
+		if opts.Metadata == nil {
+			opts.Metadata = make(map[string]string)
+		}
+		opts.Metadata[name] = r.Replace(t)
+	}
+	if len(config.OutputTags) != 0 {
+		opts.Tags = make(map[string]string, len(config.OutputTags))
+		for key, t := range config.OutputTags {
+			opts.Tags[key] = r.Replace(t)
+		}
+	}
+	return opts
+}
+
+// do any of the output metadata or tag templates use the placeholder
+func (cfg ServiceConfig) outputTemplatesUse(placeholder string) bool {
+	for _, t := range cfg.OutputMetadata {
+		if strings.Contains(t, placeholder) == true {
+			return true
+		}
+	}
+	for _, t := range cfg.OutputTags {
+		if strings.Contains(t, placeholder) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// the converter versions by binary and version option, they do not change while the service runs
+var converterVersions sync.Map
+
+// the first line the converter reports for the version option, empty if it cannot be determined (it is tried
+// again next time)
+func converterVersion(binary string, versionOpt string) string {
+
+	id := binary + " " + versionOpt
+	if v, ok := converterVersions.Load(id); ok == true {
+		return v.(string)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), converterCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary)
+	if len(versionOpt) != 0 {
+		cmd = exec.CommandContext(ctx, binary, versionOpt)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	version := strings.TrimSpace(line)
+	converterVersions.Store(id, version)
+	return version
+}
+
+//
+// end of file
+//
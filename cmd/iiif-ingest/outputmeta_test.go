@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateOutputTemplate(t *testing.T) {
+
+	for _, tmpl := range []string{"", "derivative", "s3://{source_bucket}/{source_key}", "{converter_version} {options_hash}"} {
+		if err := validateOutputTemplate(tmpl); err != nil {
+			t.Fatalf("expected '%s' to be valid (%v)", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"{sourcekey}", "{:1}", "{}"} {
+		if err := validateOutputTemplate(tmpl); err == nil {
+			t.Fatalf("expected '%s' to be invalid", tmpl)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+
+	cfg := ServiceConfig{OutputContentTypes: map[string]string{"jp2": "image/jpx"}}
+	for suffix, expected := range map[string]string{"jp2": "image/jpx", "tif": "image/tiff", "TIFF": "image/tiff", "zzz": ""} {
+		if got := cfg.contentType(suffix); got != expected {
+			t.Fatalf("expected '%s' for '%s', got '%s'", expected, suffix, got)
+		}
+	}
+}
+
+func TestConverterVersion(t *testing.T) {
+
+	converter := filepath.Join(t.TempDir(), "convert")
+	err := os.WriteFile(converter, []byte("#!/bin/sh\necho \"Version: 2.5 $1\"\necho more\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := converterVersion(converter, "-version"); v != "Version: 2.5 -version" {
+		t.Fatalf("unexpected version '%s'", v)
+	}
+	if v := converterVersion(filepath.Join(t.TempDir(), "missing"), "-version"); v != "" {
+		t.Fatalf("expected no version, got '%s'", v)
+	}
+}
+
+//
+// end of file
+//
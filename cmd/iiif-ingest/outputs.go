@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// how the output root and output bucket are used when both are set
+const (
+	outputsSingle = "single" // only one of them may be set
+	outputsBoth   = "both"   // the output root is the default destination and the bucket a required target
+)
+
+// the failure policies of output targets
+const (
+	policyRequired   = "required"    // the job fails (and is retried) if the output cannot be written
+	policyBestEffort = "best-effort" // a failure is logged and counted but the job completes
+)
+
+var outputWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "iiif_ingest_output_writes_total",
+	Help: "The number of converted files written by destination, policy and outcome",
+}, []string{"destination", "policy", "outcome"})
+
+// OutputTarget is a destination every converted file is written to as well as the one selected for the job
+type OutputTarget struct {
+	Destination Destination // where the output is written
+	Policy      string      // what happens should the write fail (required or best-effort)
+}
+
+func (t OutputTarget) String() string {
+	return fmt.Sprintf("%s (%s)", t.Destination, t.Policy)
+}
+
+// parse an output target of the form 'destination[;policy]', the policy defaults to required
+func parseOutputTarget(spec string) (OutputTarget, error) {
+
+	s := strings.SplitN(spec, ";", 2)
+	target := OutputTarget{Policy: policyRequired}
+	if len(s) == 2 {
+		target.Policy = strings.TrimSpace(s[1])
+	}
+	if validPolicy(target.Policy) == false {
+		return target, fmt.Errorf("unsupported policy '%s', expected %s or %s", target.Policy, policyRequired, policyBestEffort)
+	}
+	dest, err := parseDestination(strings.TrimSpace(s[0]))
+	if err != nil {
+		return target, err
+	}
+	target.Destination = dest
+	return target, nil
+}
+
+func validPolicy(policy string) bool {
+	return policy == policyRequired || policy == policyBestEffort
+}
+
+// the destinations a job writes its output to; the one selected for the job (which is always required)
+// followed by any configured targets that are different from it
+func outputTargets(config ServiceConfig, dest Destination) []OutputTarget {
+	targets := []OutputTarget{{Destination: dest, Policy: policyRequired}}
+	for _, t := range config.OutputTargets {
+		if t.Destination != dest {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// the details of a converted file being written to its destinations
+type convertedOutput struct {
+	name     string        // the generated output name, each destination applies its own prefix
+	workFile string        // the converted file
+	opts     PutOptions    // the upload options for buckets
+	sidecar  OutputSidecar // the sidecar for filesystems (when enabled)
+}
+
+// write the converted file to every target. A failure to write to a best-effort target is logged and counted;
+// the error returned covers the required targets that could not be written. Returns the locations written
+func writeOutputs(log *slog.Logger, config ServiceConfig, objects ObjectStore, targets []OutputTarget, out convertedOutput) ([]string, error) {
+
+	var written []string
+	var errs []error
+	for _, t := range targets {
+		start := time.Now()
+		location := t.Destination.location(config.targetName(t.Destination, out.name))
+		err := writeOutput(log, config, objects, t.Destination, out)
+		outputWrites.WithLabelValues(t.Destination.String(), t.Policy, outcomeLabel(err)).Inc()
+		if err == nil {
+			log.Debug("output written", "to", location, "seconds", time.Since(start).Seconds())
+			written = append(written, location)
+			continue
+		}
+		if t.Policy == policyBestEffort {
+			log.Warn("failed to write best effort output", "to", location, "error", err)
+			continue
+		}
+		log.Error("failed to write output", "to", location, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", location, err))
+	}
+	return written, errors.Join(errs...)
+}
+
+// write the converted file to a single destination
+func writeOutput(log *slog.Logger, config ServiceConfig, objects ObjectStore, dest Destination, out convertedOutput) error {
+
+	name := config.targetName(dest, out.name)
+	if len(dest.Bucket) != 0 {
+		// the store verifies the checksums and keeps them in the metadata
+		return objects.PutFromFile(dest.Bucket, name, out.workFile, out.opts)
+	}
+
+	fsOpts, err := config.fsOutputOptions()
+	if err != nil {
+		return err
+	}
+	fullOutputFile := filepath.Join(dest.FSRoot, filepath.FromSlash(name))
+	log.Debug("creating directory", "directory", filepath.Dir(fullOutputFile))
+	err = createOutputDirectories(dest.FSRoot, fullOutputFile, fsOpts)
+	if err == nil {
+		err = copyFile(log, out.workFile, fullOutputFile)
+	}
+	if err == nil {
+		err = setOutputOwnership(fullOutputFile, fsOpts.fileMode, fsOpts.gid)
+	}
+	if err == nil && config.OutputSidecar == true {
+		sidecar := out.sidecar
+		sidecar.Output = dest.location(name)
+		err = writeOutputSidecar(fullOutputFile, sidecar)
+		if err == nil {
+			err = setOutputOwnership(fmt.Sprintf("%s.json", fullOutputFile), fsOpts.fileMode, fsOpts.gid)
+		}
+	}
+	return err
+}
+
+//
+// end of file
+//
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseOutputTarget(t *testing.T) {
+
+	target, err := parseOutputTarget("s3://preservation/masters")
+	if err != nil || target.Destination != (Destination{Bucket: "preservation", KeyPrefix: "masters"}) || target.Policy != policyRequired {
+		t.Fatalf("unexpected target %+v (%v)", target, err)
+	}
+	target, err = parseOutputTarget("/mnt/efs/iiif; best-effort")
+	if err != nil || target.Destination != (Destination{FSRoot: "/mnt/efs/iiif"}) || target.Policy != policyBestEffort {
+		t.Fatalf("unexpected target %+v (%v)", target, err)
+	}
+	for _, spec := range []string{"s3://preservation;sometimes", "relative/path", "s3://"} {
+		if _, err = parseOutputTarget(spec); err == nil {
+			t.Fatalf("expected '%s' to be invalid", spec)
+		}
+	}
+}
+
+func TestOutputTargets(t *testing.T) {
+
+	dest := Destination{Bucket: "out"}
+	cfg := ServiceConfig{OutputTargets: []OutputTarget{
+		{Destination: dest, Policy: policyBestEffort},
+		{Destination: Destination{FSRoot: "/mnt/efs"}, Policy: policyBestEffort},
+	}}
+	// the job destination is not written twice and is always required
+	targets := outputTargets(cfg, dest)
+	if len(targets) != 2 || targets[0].Policy != policyRequired || targets[1].Destination.FSRoot != "/mnt/efs" {
+		t.Fatalf("unexpected targets %+v", targets)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var workerTarget = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_worker_target",
+	Help: "The number of workers allowed to run jobs at once",
+})
+
+var converterKills = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "iiif_ingest_converter_killed_total",
+	Help: "The number of conversions killed, usually by the out of memory killer",
+})
+
+var cpuUtilization = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_cpu_utilization",
+	Help: "The CPU utilization (0 to 1) of the task or host, as sampled by the adaptive worker pool",
+})
+
+var memoryUtilization = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "iiif_ingest_memory_utilization",
+	Help: "The memory utilization (0 to 1) of the task or host, as sampled by the adaptive worker pool",
+})
+
+// workerPool limits how many workers run jobs at once. Without adaptive workers there is no limit beyond the
+// number of workers; with them the limit grows while there is CPU and memory headroom and shrinks when the
+// host is saturated or conversions are killed for lack of memory. The limit is shared between the lanes in
+// proportion to their workers and every lane may always run at least one job, so a busy lane cannot starve
+// the others. A worker holds its slot from before it takes a notification until the job is done, and is busy
+// from when it takes one
+type workerPool struct {
+	sync.Mutex
+	cond    *sync.Cond
+	lanes   []Lane           // the lanes sharing the pool
+	backlog func(ix int) int // the notifications waiting in a lane (nil if unknown)
+	target  int              // the number of workers allowed to run jobs (0 for no limit)
+	active  []int            // the number of workers holding a slot by lane
+	busy    []int            // the number of workers with a job by lane
+	waiting []int            // the number of workers waiting for a slot by lane
+	killed  int              // conversions killed since the last adjustment
+}
+
+func newWorkerPool(lanes []Lane, backlog func(ix int) int) *workerPool {
+	p := &workerPool{lanes: lanes, backlog: backlog, active: make([]int, len(lanes)), busy: make([]int, len(lanes)),
+		waiting: make([]int, len(lanes))}
+	p.cond = sync.NewCond(&p.Mutex)
+	return p
+}
+
+// the number of workers allowed to run jobs in a lane (0 for no limit), called with the lock held
+func (p *workerPool) laneTarget(ix int) int {
+	if p.target == 0 {
+		return 0
+	}
+	total := 0
+	for _, l := range p.lanes {
+		total += l.Workers
+	}
+	return max(p.target*p.lanes[ix].Workers/max(total, 1), 1)
+}
+
+// wait until a worker in the lane may take another job
+func (p *workerPool) acquire(ix int) {
+	p.Lock()
+	defer p.Unlock()
+	p.waiting[ix]++
+	for p.target != 0 && p.active[ix] >= p.laneTarget(ix) {
+		p.cond.Wait()
+	}
+	p.waiting[ix]--
+	p.active[ix]++
+}
+
+// note a worker in the lane has taken a job
+func (p *workerPool) begin(ix int) {
+	p.Lock()
+	defer p.Unlock()
+	p.busy[ix]++
+}
+
+// note a job in the lane has finished
+func (p *workerPool) release(ix int) {
+	p.Lock()
+	defer p.Unlock()
+	p.active[ix]--
+	p.busy[ix] = max(p.busy[ix]-1, 0)
+	p.cond.Broadcast()
+}
+
+// the number of workers in each lane that could start a job now, those allowed by the lane target that
+// are not busy
+func (p *workerPool) idle() []int {
+	p.Lock()
+	defer p.Unlock()
+	idle := make([]int, len(p.lanes))
+	for ix, l := range p.lanes {
+		allowed := l.Workers
+		if p.target != 0 {
+			allowed = min(allowed, p.laneTarget(ix))
+		}
+		idle[ix] = max(allowed-p.busy[ix], 0)
+	}
+	return idle
+}
+
+func (p *workerPool) setTarget(target int) {
+	p.Lock()
+	defer p.Unlock()
+	p.target = target
+	workerTarget.Set(float64(target))
+	p.cond.Broadcast()
+}
+
+// note a conversion was killed
+func (p *workerPool) converterKilled() {
+	p.Lock()
+	defer p.Unlock()
+	p.killed++
+	converterKills.Inc()
+}
+
+// is there a lane with work waiting that its workers are not allowed to start, called with the lock held
+func (p *workerPool) demand() bool {
+	for ix := range p.lanes {
+		if p.waiting[ix] > 0 && p.active[ix] >= p.laneTarget(ix) && (p.backlog == nil || p.backlog(ix) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// adjust the target for the sampled resource usage
+func (p *workerPool) adjust(config ServiceConfig, usage resourceUsage) {
+
+	p.Lock()
+	current := p.target
+	killed := p.killed
+	p.killed = 0
+	// more workers only help if they all have something to do
+	demand := p.demand()
+	p.Unlock()
+
+	target := nextWorkerTarget(config, current, usage, killed, demand)
+	if target != current {
+		slog.Info("worker target changed", "from", current, "to", target, "cpu", usage.cpu, "memory", usage.memory, "killed", killed)
+		p.setTarget(target)
+	}
+}
+
+// the next worker target; halve it if conversions were killed, reduce it if CPU or memory is above the high
+// water mark and increase it if there is demand and plenty of headroom
+func nextWorkerTarget(config ServiceConfig, current int, usage resourceUsage, killed int, demand bool) int {
+
+	cpuHigh := float64(config.CPUHighWater) / 100
+	memoryHigh := float64(config.MemoryHighWater) / 100
+
+	target := current
+	switch {
+	case killed != 0:
+		target = current / 2
+	case usage.cpu > cpuHigh || usage.memory > memoryHigh:
+		target = current - 1
+	case demand == true && usage.cpu < cpuHigh*adaptHeadroom && usage.memory < memoryHigh*adaptHeadroom:
+		target = current + 1
+	}
+	return min(max(target, config.MinWorkers), config.Workers)
+}
+
+// the fraction of the high water marks below which the pool may grow, so it does not oscillate
+var adaptHeadroom = 0.8
+
+// periodically adjust the worker pool to the resource usage, the settings apply from the next adjustment
+// after a reload
+func adaptWorkers(holder *configHolder, pool *workerPool) {
+
+	config := holder.Get()
+	pool.setTarget(config.MinWorkers)
+	slog.Info("adaptive workers enabled", "min", config.MinWorkers, "max", config.Workers)
+
+	sampler := newResourceSampler()
+	for {
+		time.Sleep(time.Duration(config.AdaptInterval) * time.Second)
+		config = holder.Get()
+
+		usage, err := sampler.sample()
+		if err != nil {
+			slog.Warn("cannot sample resource usage", "error", err)
+			continue
+		}
+		cpuUtilization.Set(usage.cpu)
+		memoryUtilization.Set(usage.memory)
+		pool.adjust(config, usage)
+	}
+}
+
+// was the conversion killed by a signal we did not send (normally the out of memory killer)
+func converterWasKilled(err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) == false {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok == true && status.Signaled() == true && status.Signal() == syscall.SIGKILL
+}
+
+//
+// end of file
+//
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextWorkerTarget(t *testing.T) {
+
+	cfg := ServiceConfig{Workers: 8, MinWorkers: 2, CPUHighWater: 90, MemoryHighWater: 80}
+	idle := resourceUsage{cpu: 0.2, memory: 0.3}
+
+	tests := []struct {
+		name    string
+		current int
+		usage   resourceUsage
+		killed  int
+		demand  bool
+		expect  int
+	}{
+		{"grows with demand and headroom", 4, idle, 0, true, 5},
+		{"steady without demand", 4, idle, 0, false, 4},
+		{"steady near the high water mark", 4, resourceUsage{cpu: 0.8, memory: 0.3}, 0, true, 4},
+		{"shrinks when CPU is saturated", 4, resourceUsage{cpu: 0.95, memory: 0.3}, 0, true, 3},
+		{"shrinks when memory is short", 4, resourceUsage{cpu: 0.2, memory: 0.9}, 0, false, 3},
+		{"halves when conversions are killed", 8, idle, 1, true, 4},
+		{"never below the minimum", 2, resourceUsage{cpu: 1, memory: 1}, 1, false, 2},
+		{"never above the maximum", 8, idle, 0, true, 8},
+	}
+	for _, test := range tests {
+		target := nextWorkerTarget(cfg, test.current, test.usage, test.killed, test.demand)
+		if target != test.expect {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expect, target)
+		}
+	}
+}
+
+func TestWorkerPoolLimitsJobs(t *testing.T) {
+
+	p := newWorkerPool([]Lane{{Name: "default", Workers: 2}}, nil)
+	p.setTarget(1)
+	p.acquire(0)
+
+	started := make(chan bool)
+	go func() {
+		p.acquire(0)
+		started <- true
+	}()
+	select {
+	case <-started:
+		t.Fatal("expected the second job to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// raising the target lets it start
+	p.setTarget(2)
+	<-started
+	p.release(0)
+	p.release(0)
+}
+
+func TestWorkerPoolLaneTargets(t *testing.T) {
+
+	lanes := []Lane{{Name: "small", MaxSize: 10, Workers: 6}, {Name: "large", Workers: 2}}
+	tests := []struct {
+		target int
+		small  int
+		large  int
+	}{
+		{0, 0, 0}, // no limit
+		{8, 6, 2},
+		{4, 3, 1},
+		{2, 1, 1}, // every lane may always run a job
+		{1, 1, 1},
+	}
+	for _, tt := range tests {
+		p := newWorkerPool(lanes, nil)
+		p.setTarget(tt.target)
+		if small, large := p.laneTarget(0), p.laneTarget(1); small != tt.small || large != tt.large {
+			t.Errorf("target %d: expected %d and %d, got %d and %d", tt.target, tt.small, tt.large, small, large)
+		}
+	}
+}
+
+func TestWorkerPoolLaneIsNotStarved(t *testing.T) {
+
+	backlog := []int{0, 0}
+	p := newWorkerPool([]Lane{{Name: "small", MaxSize: 10, Workers: 1}, {Name: "large", Workers: 1}}, func(ix int) int { return backlog[ix] })
+	p.setTarget(1)
+
+	// a busy small lane does not stop the large lane starting a job
+	p.acquire(0)
+	started := make(chan bool)
+	go func() {
+		p.acquire(1)
+		started <- true
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("expected the large lane to start a job")
+	}
+
+	// a worker waiting for a slot is only demand if its lane has work waiting
+	go p.acquire(0)
+	time.Sleep(50 * time.Millisecond)
+	p.Lock()
+	idle := p.demand()
+	backlog[0] = 1
+	waiting := p.demand()
+	p.Unlock()
+	if idle == true || waiting == false {
+		t.Fatalf("unexpected demand %t without and %t with a backlog", idle, waiting)
+	}
+	p.setTarget(0)
+}
+
+func TestResourceSamplerHost(t *testing.T) {
+
+	root := t.TempDir()
+	saveProc, saveCgroup := procRoot, cgroupRoot
+	procRoot, cgroupRoot = root, filepath.Join(root, "nocgroup")
+	defer func() { procRoot, cgroupRoot = saveProc, saveCgroup }()
+
+	write := func(name string, content string) {
+		err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("meminfo", "MemTotal:       1000 kB\nMemFree:         100 kB\nMemAvailable:    250 kB\n")
+	write("stat", "cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 100 0 100 800 0 0 0 0 0 0\n")
+
+	s := newResourceSampler()
+	write("stat", "cpu  175 0 175 850 0 0 0 0 0 0\ncpu0 175 0 175 850 0 0 0 0 0 0\n")
+	usage, err := s.sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.cpu != 0.75 || usage.memory != 0.75 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestResourceSamplerCgroupMemory(t *testing.T) {
+
+	root := t.TempDir()
+	saveCgroup := cgroupRoot
+	cgroupRoot = root
+	defer func() { cgroupRoot = saveCgroup }()
+
+	_ = os.WriteFile(filepath.Join(root, "memory.max"), []byte("1000\n"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "memory.current"), []byte("700\n"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "memory.stat"), []byte("anon 500\ninactive_file 200\n"), 0644)
+
+	used, err := memoryUsed()
+	if err != nil || used != 0.5 {
+		t.Fatalf("unexpected memory utilization %f (%v)", used, err)
+	}
+}
+
+//
+// end of file
+//
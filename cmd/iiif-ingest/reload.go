@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configHolder holds the current service configuration and allows it to be replaced while workers are running.
+// Workers take a snapshot at the start of each job so in-flight jobs are unaffected by a reload
+type configHolder struct {
+	sync.RWMutex
+	config ServiceConfig
+}
+
+func newConfigHolder(config ServiceConfig) *configHolder {
+	return &configHolder{config: config}
+}
+
+// Get a snapshot of the current configuration
+func (h *configHolder) Get() ServiceConfig {
+	h.RLock()
+	defer h.RUnlock()
+	return h.config
+}
+
+func (h *configHolder) set(config ServiceConfig) {
+	h.Lock()
+	defer h.Unlock()
+	h.config = config
+}
+
+// watch for SIGHUP and (if we have one) changes to the configuration file and reload the configuration when
+// either occurs. Should be run as a goroutine
+func configReloader(holder *configHolder, pollInterval time.Duration) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// a nil channel blocks forever so the file is not polled if we do not have one
+	var poll <-chan time.Time
+	current := holder.Get()
+	lastModified := configFileModified(current.ConfigFile)
+	if len(current.ConfigFile) != 0 && pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("received SIGHUP, reloading configuration")
+		case <-poll:
+			modified := configFileModified(current.ConfigFile)
+			if modified.Equal(lastModified) == true {
+				continue
+			}
+			lastModified = modified
+			slog.Info("configuration file has changed, reloading configuration", "file", current.ConfigFile)
+		}
+
+		reloadConfiguration(holder)
+		current = holder.Get()
+	}
+}
+
+// reload the configuration, replacing the current one only if the new one is valid
+func reloadConfiguration(holder *configHolder) {
+
+	current := holder.Get()
+	cfg, errs := loadConfiguration()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			slog.Error("configuration error", "error", err)
+		}
+		slog.Error("configuration reload failed, continuing with existing configuration")
+		return
+	}
+
+	// some settings are only used at startup
+	ignored := restoreStartupSettings(current, cfg)
+	if len(ignored) != 0 {
+		slog.Warn("settings cannot be changed without a restart, ignoring", "settings", strings.Join(ignored, ", "))
+	}
+
+	// the audit log is opened at startup so deletion cannot be enabled without one
+	if cfg.auditRequired() == true && len(cfg.AuditLog) == 0 {
+		slog.Error("source removal requires an audit log configured at startup, continuing with existing configuration")
+		return
+	}
+
+	changes := configDiff(current, *cfg)
+	if len(changes) == 0 {
+		slog.Info("configuration reloaded, no changes")
+		return
+	}
+
+	for _, line := range changes {
+		slog.Info("configuration changed", "change", line)
+	}
+	holder.set(*cfg)
+	applyLogLevel(cfg.LogLevel)
+	slog.Info("configuration reloaded, changes apply to new jobs")
+}
+
+// restore the settings that are only used at startup and return the names of any that were changed
+func restoreStartupSettings(current ServiceConfig, cfg *ServiceConfig) []string {
+
+	ignored := make([]string, 0)
+	restoreString := func(name string, from string, to *string) {
+		if *to != from {
+			ignored = append(ignored, name)
+			*to = from
+		}
+	}
+	restoreInt := func(name string, from int, to *int) {
+		if *to != from {
+			ignored = append(ignored, name)
+			*to = from
+		}
+	}
+
+	restoreBool := func(name string, from bool, to *bool) {
+		if *to != from {
+			ignored = append(ignored, name)
+			*to = from
+		}
+	}
+
+	restoreString("InQueueName", current.InQueueName, &cfg.InQueueName)
+	restoreInt("LocalVisibility", current.LocalVisibility, &cfg.LocalVisibility)
+	restoreInt("WorkerQueueSize", current.WorkerQueueSize, &cfg.WorkerQueueSize)
+	restoreInt("Workers", current.Workers, &cfg.Workers)
+	restoreString("HttpListen", current.HttpListen, &cfg.HttpListen)
+	restoreString("LogFormat", current.LogFormat, &cfg.LogFormat)
+	restoreString("JobStore", current.JobStore, &cfg.JobStore)
+	restoreString("AuditLog", current.AuditLog, &cfg.AuditLog)
+	restoreString("EventQueueName", current.EventQueueName, &cfg.EventQueueName)
+	restoreString("ObjectStore", current.ObjectStore, &cfg.ObjectStore)
+	restoreString("S3Endpoint", current.S3Endpoint, &cfg.S3Endpoint)
+	restoreString("S3Region", current.S3Region, &cfg.S3Region)
+	restoreString("S3AccessKey", current.S3AccessKey, &cfg.S3AccessKey)
+	restoreString("S3SecretKey", current.S3SecretKey, &cfg.S3SecretKey)
+	restoreBool("S3PathStyle", current.S3PathStyle, &cfg.S3PathStyle)
+	restoreInt("S3PartSize", current.S3PartSize, &cfg.S3PartSize)
+	restoreInt("S3Concurrency", current.S3Concurrency, &cfg.S3Concurrency)
+	restoreInt("S3MaxRetries", current.S3MaxRetries, &cfg.S3MaxRetries)
+	restoreBool("AdaptiveWorkers", current.AdaptiveWorkers, &cfg.AdaptiveWorkers)
+	if fmt.Sprint(cfg.Lanes) != fmt.Sprint(current.Lanes) {
+		ignored = append(ignored, "Lanes")
+		cfg.Lanes = current.Lanes
+	}
+	return ignored
+}
+
+// the differences between two configurations; removed settings are prefixed with '-' and added ones with '+'
+func configDiff(before ServiceConfig, after ServiceConfig) []string {
+
+	beforeLines := before.describe()
+	afterLines := after.describe()
+
+	beforeSet := make(map[string]bool, len(beforeLines))
+	for _, line := range beforeLines {
+		beforeSet[line] = true
+	}
+	afterSet := make(map[string]bool, len(afterLines))
+	for _, line := range afterLines {
+		afterSet[line] = true
+	}
+
+	var changes []string
+	for _, line := range beforeLines {
+		if afterSet[line] == false {
+			changes = append(changes, "- "+line)
+		}
+	}
+	for _, line := range afterLines {
+		if beforeSet[line] == false {
+			changes = append(changes, "+ "+line)
+		}
+	}
+	return changes
+}
+
+// the modification time of the configuration file, zero if we do not have one or it cannot be read
+func configFileModified(filename string) time.Time {
+	if len(filename) == 0 {
+		return time.Time{}
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+//
+// end of file
+//
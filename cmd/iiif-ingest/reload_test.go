@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the configuration running before a reload
+func startupConfig() ServiceConfig {
+	return ServiceConfig{
+		InQueueName:     "inbound",
+		WorkerQueueSize: 4,
+		Workers:         2,
+		HttpListen:      ":8080",
+		LogFormat:       "json",
+		JobStore:        "sqlite:///data/jobs.db",
+		AuditLog:        "stderr",
+		ObjectStore:     "s3",
+		S3Region:        "us-east-1",
+		S3PartSize:      16,
+		Lanes:           []Lane{{Name: "small", MaxSize: 100, Workers: 1}, {Name: "large", Workers: 1}},
+		LogLevel:        "info",
+		OutputBucket:    "derivatives",
+	}
+}
+
+func TestRestoreStartupSettings(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		change  func(cfg *ServiceConfig)
+		ignored []string // the settings expected to be reported as ignored
+	}{
+		{"nothing changed", func(cfg *ServiceConfig) {}, []string{}},
+		{"inbound queue", func(cfg *ServiceConfig) { cfg.InQueueName = "other" }, []string{"InQueueName"}},
+		{"workers", func(cfg *ServiceConfig) { cfg.Workers = 4 }, []string{"Workers"}},
+		{"listen address", func(cfg *ServiceConfig) { cfg.HttpListen = ":9090" }, []string{"HttpListen"}},
+		{"log format", func(cfg *ServiceConfig) { cfg.LogFormat = "text" }, []string{"LogFormat"}},
+		{"job store", func(cfg *ServiceConfig) { cfg.JobStore = "" }, []string{"JobStore"}},
+		{"object store", func(cfg *ServiceConfig) { cfg.ObjectStore = "file:///data" }, []string{"ObjectStore"}},
+		{"part size", func(cfg *ServiceConfig) { cfg.S3PartSize = 8 }, []string{"S3PartSize"}},
+		{"lanes", func(cfg *ServiceConfig) { cfg.Lanes = nil }, []string{"Lanes"}},
+		{"several", func(cfg *ServiceConfig) { cfg.InQueueName = "other"; cfg.AuditLog = "" }, []string{"InQueueName", "AuditLog"}},
+		{"reloadable", func(cfg *ServiceConfig) { cfg.LogLevel = "debug"; cfg.OutputBucket = "elsewhere" }, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := startupConfig()
+			cfg := startupConfig()
+			tt.change(&cfg)
+			reloaded := cfg
+
+			ignored := restoreStartupSettings(current, &cfg)
+			if reflect.DeepEqual(ignored, tt.ignored) == false {
+				t.Fatalf("expected %v ignored, got %v", tt.ignored, ignored)
+			}
+			// the startup settings keep their original values and the others take the reloaded ones
+			expect := current
+			expect.LogLevel = reloaded.LogLevel
+			expect.OutputBucket = reloaded.OutputBucket
+			if reflect.DeepEqual(cfg, expect) == false {
+				t.Fatalf("expected %+v, got %+v", expect, cfg)
+			}
+		})
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		change  func(cfg *ServiceConfig)
+		changed []string // the settings expected in the diff
+	}{
+		{"nothing changed", func(cfg *ServiceConfig) {}, nil},
+		{"log level", func(cfg *ServiceConfig) { cfg.LogLevel = "debug" }, []string{"LogLevel"}},
+		{"output bucket and prefix", func(cfg *ServiceConfig) { cfg.OutputBucket = "elsewhere"; cfg.OutputKeyPrefix = "iiif" },
+			[]string{"OutputBucket", "OutputKeyPrefix"}},
+		{"convert option added", func(cfg *ServiceConfig) { cfg.ConvertOptions = map[string]string{"*": "-rate 1"} },
+			[]string{"Convert options map"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := startupConfig()
+			after := startupConfig()
+			tt.change(&after)
+
+			// every changed setting appears once as removed and once as added, except a new map entry
+			changed := make(map[string]bool)
+			for _, line := range configDiff(before, after) {
+				name := strings.TrimSpace(strings.SplitN(line[2:], "=", 2)[0])
+				changed[name] = true
+			}
+			expect := make(map[string]bool)
+			for _, name := range tt.changed {
+				expect[name] = true
+			}
+			if reflect.DeepEqual(changed, expect) == false {
+				t.Fatalf("expected changes to %v, got %v", tt.changed, configDiff(before, after))
+			}
+		})
+	}
+}
+
+//
+// end of file
+//
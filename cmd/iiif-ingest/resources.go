@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// where the kernel reports resource usage, the cgroup (v2) limits apply when running in a container
+var procRoot = "/proc"
+var cgroupRoot = "/sys/fs/cgroup"
+
+// the CPU and memory utilization (0 to 1)
+type resourceUsage struct {
+	cpu    float64
+	memory float64
+}
+
+// resourceSampler reports the CPU utilization since the previous sample and the current memory utilization,
+// using the cgroup limits when there are any and the host otherwise
+type resourceSampler struct {
+	lastBusy  float64 // the busy CPU time at the last sample (in seconds)
+	lastTotal float64 // the available CPU time at the last sample (in seconds)
+}
+
+func newResourceSampler() *resourceSampler {
+	s := &resourceSampler{}
+	s.lastBusy, s.lastTotal, _ = cpuTimes()
+	return s
+}
+
+func (s *resourceSampler) sample() (resourceUsage, error) {
+
+	var usage resourceUsage
+	busy, total, err := cpuTimes()
+	if err != nil {
+		return usage, err
+	}
+	if total > s.lastTotal {
+		usage.cpu = min((busy-s.lastBusy)/(total-s.lastTotal), 1)
+	}
+	s.lastBusy, s.lastTotal = busy, total
+
+	usage.memory, err = memoryUsed()
+	return usage, err
+}
+
+// the busy and available CPU time, from the cgroup if it has a CPU limit and the host otherwise
+func cpuTimes() (float64, float64, error) {
+
+	limit, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu.max"))
+	if err == nil {
+		f := strings.Fields(string(limit))
+		if len(f) == 2 && f[0] != "max" {
+			quota, err1 := strconv.ParseFloat(f[0], 64)
+			period, err2 := strconv.ParseFloat(f[1], 64)
+			stat, err3 := readKeyValues(filepath.Join(cgroupRoot, "cpu.stat"))
+			if err1 == nil && err2 == nil && err3 == nil && period > 0 {
+				// the available time is the wall clock time scaled by the CPU limit
+				wall := float64(time.Now().UnixNano()) / 1e9
+				return stat["usage_usec"] / 1e6, wall * quota / period, nil
+			}
+		}
+	}
+
+	buf, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	line, _, _ := strings.Cut(string(buf), "\n")
+	f := strings.Fields(line)
+	if len(f) < 5 || f[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected %s/stat format", procRoot)
+	}
+	var total, idle float64
+	for ix, v := range f[1:] {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += n
+		// idle and iowait
+		if ix == 3 || ix == 4 {
+			idle += n
+		}
+	}
+	return total - idle, total, nil
+}
+
+// the fraction of memory in use, from the cgroup if it has a memory limit and the host otherwise. Reclaimable
+// page cache is not counted as used
+func memoryUsed() (float64, error) {
+
+	limit, err := os.ReadFile(filepath.Join(cgroupRoot, "memory.max"))
+	if err == nil && strings.TrimSpace(string(limit)) != "max" {
+		limitBytes, err1 := strconv.ParseFloat(strings.TrimSpace(string(limit)), 64)
+		current, err2 := os.ReadFile(filepath.Join(cgroupRoot, "memory.current"))
+		stat, err3 := readKeyValues(filepath.Join(cgroupRoot, "memory.stat"))
+		if err1 == nil && err2 == nil && err3 == nil && limitBytes > 0 {
+			used, err := strconv.ParseFloat(strings.TrimSpace(string(current)), 64)
+			if err == nil {
+				return (used - stat["inactive_file"]) / limitBytes, nil
+			}
+		}
+	}
+
+	info, err := readKeyValues(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+	if info["MemTotal:"] == 0 {
+		return 0, fmt.Errorf("unexpected %s/meminfo format", procRoot)
+	}
+	return 1 - info["MemAvailable:"]/info["MemTotal:"], nil
+}
+
+// read a file of 'key value' lines, values that are not numbers are ignored
+func readKeyValues(filename string) (map[string]float64, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, scanner.Err()
+}
+
+//
+// end of file
+//
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Destination describes where converted files are written
+type Destination struct {
+	FSRoot    string // the output root directory
+	Bucket    string // the output bucket
+	KeyPrefix string // the prefix prepended to generated output names
+}
+
+// OutputRoute selects the destination for objects from a source bucket and/or key prefix
+type OutputRoute struct {
+	SourceBucket string      // the source bucket name, empty matches any bucket
+	KeyPrefix    string      // the source key prefix, empty matches any key
+	Destination  Destination // the destination for matching objects
+}
+
+// is the destination specified
+func (d Destination) isSet() bool {
+	return len(d.FSRoot) != 0 || len(d.Bucket) != 0
+}
+
+// ensure exactly one of a filesystem root or a bucket is specified
+func (d Destination) validate() error {
+	if len(d.FSRoot) == 0 && len(d.Bucket) == 0 {
+		return fmt.Errorf("must specify an output root or an output bucket")
+	}
+	if len(d.FSRoot) != 0 && len(d.Bucket) != 0 {
+		return fmt.Errorf("cannot specify an output root and an output bucket")
+	}
+	return nil
+}
+
+// the full output name (relative to the root or bucket) for the generated name
+func (d Destination) outputName(name string) string {
+	if len(d.KeyPrefix) == 0 {
+		return name
+	}
+	return path.Join(d.KeyPrefix, name)
+}
+
+// the full location of an output name (as returned by outputName)
+func (d Destination) location(outputName string) string {
+	if len(d.Bucket) != 0 {
+		return fmt.Sprintf("s3://%s/%s", d.Bucket, outputName)
+	}
+	return path.Join(d.FSRoot, outputName)
+}
+
+func (d Destination) String() string {
+	if len(d.Bucket) != 0 {
+		return fmt.Sprintf("s3://%s", path.Join(d.Bucket, d.KeyPrefix))
+	}
+	return path.Join(d.FSRoot, d.KeyPrefix)
+}
+
+// does this route match the specified bucket and key
+func (r OutputRoute) matches(bucket string, key string) bool {
+
+	if len(r.SourceBucket) != 0 && r.SourceBucket != bucket {
+		return false
+	}
+
+	return strings.HasPrefix(key, r.KeyPrefix)
+}
+
+// parse a route specification of the form 'bucket[/prefix]=destination' where bucket may be '*' to match any
+// bucket and destination is either 's3://bucket[/prefix]' or an absolute filesystem path
+func parseOutputRoute(spec string) (OutputRoute, error) {
+
+	var route OutputRoute
+	s := strings.SplitN(spec, "=", 2)
+	if len(s) != 2 {
+		return route, fmt.Errorf("expected 'source=destination'")
+	}
+
+	source := strings.SplitN(strings.TrimSpace(s[0]), "/", 2)
+	if source[0] != "*" {
+		route.SourceBucket = source[0]
+	}
+	if len(source) == 2 {
+		route.KeyPrefix = source[1]
+	}
+
+	dest, err := parseDestination(strings.TrimSpace(s[1]))
+	if err != nil {
+		return route, err
+	}
+	route.Destination = dest
+	return route, nil
+}
+
+// parse a destination of the form 's3://bucket[/prefix]' or an absolute filesystem path
+func parseDestination(spec string) (Destination, error) {
+
+	var dest Destination
+	if strings.HasPrefix(spec, "s3://") == true {
+		b := strings.SplitN(strings.TrimPrefix(spec, "s3://"), "/", 2)
+		dest.Bucket = b[0]
+		if len(b) == 2 {
+			dest.KeyPrefix = strings.Trim(b[1], "/")
+		}
+		if len(dest.Bucket) == 0 {
+			return dest, fmt.Errorf("missing bucket name in '%s'", spec)
+		}
+		return dest, nil
+	}
+
+	if path.IsAbs(spec) == false {
+		return dest, fmt.Errorf("destination '%s' must be s3://bucket[/prefix] or an absolute path", spec)
+	}
+	dest.FSRoot = path.Clean(spec)
+	return dest, nil
+}
+
+// resolve the destination for an inbound object. The matching rule destination is used if specified, otherwise
+// the first matching output route, otherwise the service default. A rule that specifies only a key prefix
+// applies it to the selected destination
+func resolveDestination(config ServiceConfig, rule *RoutingRule, bucket string, key string) (Destination, error) {
+
+	if rule.Destination.isSet() == true {
+		return rule.Destination, nil
+	}
+
+	dest := config.defaultDestination()
+	for _, r := range config.Routes {
+		if r.matches(bucket, key) == true {
+			dest = r.Destination
+			break
+		}
+	}
+
+	if dest.isSet() == false {
+		return dest, fmt.Errorf("no output destination for s3://%s/%s", bucket, key)
+	}
+
+	if len(rule.Destination.KeyPrefix) != 0 {
+		dest.KeyPrefix = rule.Destination.KeyPrefix
+	}
+	return dest, nil
+}
+
+//
+// end of file
+//
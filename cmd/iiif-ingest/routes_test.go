@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOutputRoute(t *testing.T) {
+
+	tests := []struct {
+		spec  string
+		route OutputRoute
+		err   string // part of the expected error (empty if valid)
+	}{
+		{"masters=s3://derivatives", OutputRoute{SourceBucket: "masters", Destination: Destination{Bucket: "derivatives"}}, ""},
+		{"masters/special/=s3://derivatives/iiif/", OutputRoute{SourceBucket: "masters", KeyPrefix: "special/",
+			Destination: Destination{Bucket: "derivatives", KeyPrefix: "iiif"}}, ""},
+		{"*/coll/ = /mnt/iiif/", OutputRoute{KeyPrefix: "coll/", Destination: Destination{FSRoot: "/mnt/iiif"}}, ""},
+		{"masters", OutputRoute{}, "expected 'source=destination'"},
+		{"masters=s3://", OutputRoute{}, "missing bucket name"},
+		{"masters=relative/path", OutputRoute{}, "must be s3://bucket[/prefix] or an absolute path"},
+	}
+	for _, tt := range tests {
+		route, err := parseOutputRoute(tt.spec)
+		if len(tt.err) != 0 {
+			if err == nil || strings.Contains(err.Error(), tt.err) == false {
+				t.Errorf("%s: expected error '%s', got %v", tt.spec, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || route != tt.route {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.spec, tt.route, route, err)
+		}
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+
+	routes := []OutputRoute{
+		{SourceBucket: "masters", KeyPrefix: "special/", Destination: Destination{Bucket: "special"}},
+		{SourceBucket: "masters", Destination: Destination{Bucket: "masters-out", KeyPrefix: "iiif"}},
+		{KeyPrefix: "special/", Destination: Destination{FSRoot: "/mnt/special"}},
+	}
+	withDefault := ServiceConfig{OutputBucket: "derivatives", Routes: routes}
+	withoutDefault := ServiceConfig{Routes: routes}
+
+	tests := []struct {
+		name   string
+		config ServiceConfig
+		rule   Destination // the rule destination
+		bucket string
+		key    string
+		expect Destination
+		err    bool
+	}{
+		{"rule over route", withDefault, Destination{Bucket: "rule-out"}, "masters", "special/item", Destination{Bucket: "rule-out"}, false},
+		{"first matching route", withDefault, Destination{}, "masters", "special/item", Destination{Bucket: "special"}, false},
+		{"later route", withDefault, Destination{}, "masters", "coll/item", Destination{Bucket: "masters-out", KeyPrefix: "iiif"}, false},
+		{"any bucket route", withDefault, Destination{}, "other", "special/item", Destination{FSRoot: "/mnt/special"}, false},
+		{"default", withDefault, Destination{}, "other", "coll/item", Destination{Bucket: "derivatives"}, false},
+		{"rule prefix overrides route prefix", withDefault, Destination{KeyPrefix: "rule"}, "masters", "coll/item",
+			Destination{Bucket: "masters-out", KeyPrefix: "rule"}, false},
+		{"rule prefix applies to default", withDefault, Destination{KeyPrefix: "rule"}, "other", "coll/item",
+			Destination{Bucket: "derivatives", KeyPrefix: "rule"}, false},
+		{"no destination", withoutDefault, Destination{KeyPrefix: "rule"}, "other", "coll/item", Destination{}, true},
+	}
+	for _, tt := range tests {
+		rule := RoutingRule{Name: "test", Destination: tt.rule}
+		dest, err := resolveDestination(tt.config, &rule, tt.bucket, tt.key)
+		if tt.err == true {
+			if err == nil || strings.Contains(err.Error(), "no output destination for s3://other/coll/item") == false {
+				t.Errorf("%s: expected a no destination error, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || dest != tt.expect {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.name, tt.expect, dest, err)
+		}
+	}
+}
+
+//
+// end of file
+//
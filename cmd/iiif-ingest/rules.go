@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RoutingRule defines how a matching inbound object is converted and named. Rules are evaluated in order
+// and the first rule whose match criteria are all satisfied is selected.
+type RoutingRule struct {
+	Name string // the rule name (for logging)
+
+	// match criteria, empty values match anything
+	SourceBucket   string // the source bucket name
+	KeyPrefix      string // the source key prefix
+	InputNameRegex string // the input name regular expression (applied to the key without the file suffix)
+	Format         string // the detected input format
+
+	// selections, empty values use the service defaults
+	ConvertOptions     string      // the conversion options, otherwise selected by file type
+	OutputNameTemplate string      // the output name template
+	ConvertSuffix      string      // the suffix of converted files
+	Destination        Destination // the output destination, otherwise selected by output route
+
+	re *regexp.Regexp // the compiled input name regex
+}
+
+// equivalent format names
+var formatAliases = map[string]string{
+	"tif":  "tiff",
+	"jpg":  "jpeg",
+	"j2k":  "jp2",
+	"jpf":  "jp2",
+	"jpx":  "jp2",
+	"heic": "heif",
+}
+
+// normalize a format name so that 'TIF', '.tif' and 'tiff' are equivalent
+func normalizeFormat(format string) string {
+	f := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+	alias, ok := formatAliases[f]
+	if ok == true {
+		return alias
+	}
+	return f
+}
+
+// detect the format of an inbound object; we use the file suffix as we match before download
+func detectFormat(key string) string {
+	return normalizeFormat(path.Ext(key))
+}
+
+// compile the input name regex, must be done before the rule is used
+func (r *RoutingRule) compile() error {
+	re, err := regexp.Compile(r.InputNameRegex)
+	if err != nil {
+		return err
+	}
+	r.re = re
+	return nil
+}
+
+// the template placeholders, e.g. {:1}
+var placeholderRegex = regexp.MustCompile(`\{:(\d+)\}`)
+
+// ensure every template placeholder refers to a regex group; the regex must have been compiled
+func (r *RoutingRule) validateTemplate() error {
+	groups := r.re.NumSubexp()
+	for _, m := range placeholderRegex.FindAllStringSubmatch(r.OutputNameTemplate, -1) {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 || n > groups {
+			return fmt.Errorf("template placeholder %s does not match a regex group (regex has %d)", m[0], groups)
+		}
+	}
+	return nil
+}
+
+// does this rule match the specified bucket and key
+func (r *RoutingRule) matches(bucket string, key string) bool {
+
+	if len(r.SourceBucket) != 0 && r.SourceBucket != bucket {
+		return false
+	}
+
+	if len(r.KeyPrefix) != 0 && strings.HasPrefix(key, r.KeyPrefix) == false {
+		return false
+	}
+
+	if len(r.Format) != 0 && r.Format != detectFormat(key) {
+		return false
+	}
+
+	// remove the file suffix
+	name := strings.TrimSuffix(key, path.Ext(key))
+	return r.re.MatchString(name)
+}
+
+// the conversion options for the specified file
+func (r *RoutingRule) convertOptions(config ServiceConfig, fileExt string) (string, bool) {
+
+	if len(r.ConvertOptions) != 0 {
+		return r.ConvertOptions, true
+	}
+
+	options, ok := config.ConvertOptions[fileExt]
+	if ok == true {
+		return options, true
+	}
+	return config.ConvertOptions["*"], false
+}
+
+//
+// end of file
+//
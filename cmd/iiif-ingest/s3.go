@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3ObjectStore adapts S3 (or an S3 compatible store) to the ObjectStore interface. Large objects are moved in
+// parts, several at once: uploads as multipart uploads and downloads as ranged GETs. The SDK sends the MD5 and
+// SHA-256 of each part so S3 verifies them, and a failed part is retried on its own rather than the whole object
+type s3ObjectStore struct {
+	svc        *s3.S3
+	downloader *s3manager.Downloader
+	uploader   *s3manager.Uploader
+}
+
+func newS3ObjectStore(config ServiceConfig) (*s3ObjectStore, error) {
+
+	sess, err := session.NewSession(config.s3Config())
+	if err != nil {
+		return nil, err
+	}
+	partSize := int64(config.S3PartSize) * 1024 * 1024
+	return &s3ObjectStore{
+		svc: s3.New(sess),
+		// an interrupted part body is retried up to the client retry limit
+		downloader: s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+			d.PartSize = partSize
+			d.Concurrency = config.S3Concurrency
+		}),
+		uploader: s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+			u.PartSize = partSize
+			u.Concurrency = config.S3Concurrency
+		}),
+	}, nil
+}
+
+// the SDK configuration, unset values use the SDK defaults (environment, shared config and instance role)
+func (cfg ServiceConfig) s3Config() *aws.Config {
+
+	c := aws.NewConfig().WithMaxRetries(cfg.S3MaxRetries)
+	if len(cfg.S3Endpoint) != 0 {
+		c = c.WithEndpoint(cfg.S3Endpoint)
+	}
+	if len(cfg.S3Region) != 0 {
+		c = c.WithRegion(cfg.S3Region)
+	}
+	if cfg.S3PathStyle == true {
+		c = c.WithS3ForcePathStyle(true)
+	}
+	if len(cfg.S3AccessKey) != 0 {
+		c = c.WithCredentials(credentials.NewStaticCredentials(cfg.S3AccessKey, cfg.S3SecretKey, ""))
+	}
+	return c
+}
+
+func (s *s3ObjectStore) StatObject(bucket string, key string) (int64, error) {
+	out, err := s.svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+func (s *s3ObjectStore) GetToFile(bucket string, key string, filename string) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = s.downloader.Download(file, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return err
+}
+
+func (s *s3ObjectStore) GetReader(bucket string, key string) (io.ReadCloser, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// a file small enough for a single PUT carries the whole object checksums, which S3 verifies before storing it.
+// A multipart upload (a file larger than the part size) is not verified as a whole; the checksums are only
+// recorded in the object metadata
+func (s *s3ObjectStore) PutFromFile(bucket string, key string, filename string, opts PutOptions) error {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	input := uploadInput(bucket, key, file, opts)
+	if fi.Size() <= s.uploader.PartSize {
+		if sum := base64Digest(opts.SHA256); len(sum) != 0 {
+			input.ChecksumSHA256 = aws.String(sum)
+		}
+		if sum := base64Digest(opts.MD5); len(sum) != 0 {
+			input.ContentMD5 = aws.String(sum)
+		}
+	}
+	_, err = s.uploader.Upload(input)
+	return err
+}
+
+// larger streams become a multipart upload, which the uploader aborts should the reader fail. The checksums
+// of a stream are not known until it ends, they are added afterwards with UpdateMetadata
+func (s *s3ObjectStore) PutFromReader(bucket string, key string, r io.Reader, opts PutOptions) error {
+	_, err := s.uploader.Upload(uploadInput(bucket, key, r, opts))
+	return err
+}
+
+func (s *s3ObjectStore) PutFromBuffer(bucket string, key string, buf []byte) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(buf)})
+	return err
+}
+
+func (s *s3ObjectStore) DeleteObject(bucket string, key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return err
+}
+
+// server side copy of an object, optionally with a different storage class. A single copy is limited to
+// 5GB by S3
+func (s *s3ObjectStore) CopyObject(srcBucket string, srcKey string, dstBucket string, dstKey string, storageClass string) error {
+
+	input := s3.CopyObjectInput{
+		CopySource: aws.String(copySource(srcBucket, srcKey)),
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+	}
+	if len(storageClass) != 0 {
+		input.StorageClass = aws.String(storageClass)
+	}
+	_, err := s.svc.CopyObject(&input)
+	return err
+}
+
+// the metadata is replaced by copying the object onto itself, S3 computes the SHA-256 of the whole copy so
+// it is verified even for a multipart upload. A single copy is limited to 5GB by S3
+func (s *s3ObjectStore) UpdateMetadata(bucket string, key string, opts PutOptions) error {
+
+	input := s3.CopyObjectInput{
+		CopySource:        aws.String(copySource(bucket, key)),
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(key),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}
+	if len(opts.Metadata) != 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	if len(opts.ContentType) != 0 {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.CacheControl) != 0 {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.StorageClass) != 0 {
+		input.StorageClass = aws.String(opts.StorageClass)
+	}
+	expected := base64Digest(opts.SHA256)
+	if len(expected) != 0 {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	out, err := s.svc.CopyObject(&input)
+	if err != nil {
+		return err
+	}
+
+	// a store that does not compute checksums does not return one
+	if out.CopyObjectResult != nil {
+		actual := aws.StringValue(out.CopyObjectResult.ChecksumSHA256)
+		if len(expected) != 0 && len(actual) != 0 && actual != expected {
+			return fmt.Errorf("sha256 mismatch, expected %s got %s", expected, actual)
+		}
+	}
+	return nil
+}
+
+// the copy source must be URL encoded
+func copySource(bucket string, key string) string {
+	segments := strings.Split(key, "/")
+	for ix := range segments {
+		segments[ix] = url.PathEscape(segments[ix])
+	}
+	return fmt.Sprintf("%s/%s", bucket, strings.Join(segments, "/"))
+}
+
+func (s *s3ObjectStore) TagObject(bucket string, key string, tagKey string, tagValue string) error {
+
+	current, err := s.svc.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+
+	tags := make([]*s3.Tag, 0, len(current.TagSet)+1)
+	for _, t := range current.TagSet {
+		if aws.StringValue(t.Key) != tagKey {
+			tags = append(tags, t)
+		}
+	}
+	tags = append(tags, &s3.Tag{Key: aws.String(tagKey), Value: aws.String(tagValue)})
+
+	_, err = s.svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tags},
+	})
+	return err
+}
+
+// the upload input with any object metadata, headers, storage class and tags
+func uploadInput(bucket string, key string, body io.Reader, opts PutOptions) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: body}
+	if len(opts.Metadata) != 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	if len(opts.ContentType) != 0 {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.CacheControl) != 0 {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.StorageClass) != 0 {
+		input.StorageClass = aws.String(opts.StorageClass)
+	}
+	if len(opts.Tags) != 0 {
+		// the tags are sent URL encoded
+		tags := url.Values{}
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	return input
+}
+
+// is the storage class one that S3 supports
+func validStorageClass(class string) bool {
+	for _, c := range s3.StorageClass_Values() {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// end of file
+//
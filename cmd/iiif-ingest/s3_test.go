@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 implements just enough of the S3 API for multipart uploads, ranged downloads and copies. It verifies
+// the Content-MD5 and any SHA-256 checksum of every upload and fails the first attempt at uploading part 2
+type fakeS3 struct {
+	sync.Mutex
+	objects  map[string][]byte
+	metadata map[string]string      // the sha256 metadata by object
+	headers  map[string]http.Header // the headers of the request creating each object
+	parts    map[string]map[int][]byte
+	failed   bool
+	requests []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), metadata: make(map[string]string), headers: make(map[string]http.Header), parts: make(map[string]map[int][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	copySource := r.Header.Get("X-Amz-Copy-Source")
+	if r.Method == http.MethodPut && len(copySource) == 0 {
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "BadDigest", http.StatusBadRequest)
+			return
+		}
+		checksum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Checksum-Sha256") != "" && r.Header.Get("X-Amz-Checksum-Sha256") != base64.StdEncoding.EncodeToString(checksum[:]) {
+			http.Error(w, "BadDigest", http.StatusBadRequest)
+			return
+		}
+	}
+	if meta := r.Header.Get("X-Amz-Meta-Sha256"); meta != "" {
+		f.metadata[name] = meta
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.requests = append(f.requests, "initiate")
+		f.headers[name] = r.Header.Clone()
+		f.parts["upload-1"] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+
+	case r.Method == http.MethodPut && q.Has("partNumber"):
+		part, _ := strconv.Atoi(q.Get("partNumber"))
+		if part == 2 && f.failed == false {
+			f.failed = true
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		f.requests = append(f.requests, fmt.Sprintf("part %d", part))
+		f.parts[q.Get("uploadId")][part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, part))
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		f.requests = append(f.requests, "complete")
+		parts := f.parts[q.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var object []byte
+		for _, n := range numbers {
+			object = append(object, parts[n]...)
+		}
+		f.objects[name] = object
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+
+	case r.Method == http.MethodPut && len(copySource) != 0:
+		f.requests = append(f.requests, "copy")
+		source, _ := url.PathUnescape(copySource)
+		object, ok := f.objects[source]
+		if ok == false {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		f.objects[name] = object
+		f.headers[name] = r.Header.Clone()
+		checksum := ""
+		if r.Header.Get("X-Amz-Checksum-Algorithm") == "SHA256" {
+			sum := sha256.Sum256(object)
+			checksum = base64.StdEncoding.EncodeToString(sum[:])
+		}
+		fmt.Fprintf(w, `<CopyObjectResult><ETag>"etag"</ETag><ChecksumSHA256>%s</ChecksumSHA256></CopyObjectResult>`, checksum)
+
+	case r.Method == http.MethodPut:
+		f.requests = append(f.requests, "put")
+		if r.Header.Get("X-Amz-Checksum-Sha256") != "" {
+			f.requests = append(f.requests, "put checksum")
+		}
+		f.objects[name] = body
+		f.headers[name] = r.Header.Clone()
+
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[name]
+		if ok == false {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		var start, end int
+		_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		if err != nil {
+			_, _ = w.Write(object)
+			return
+		}
+		end = min(end, len(object)-1)
+		f.requests = append(f.requests, fmt.Sprintf("range %d-%d", start, end))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(object[start : end+1])
+
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func TestS3MultipartTransfers(t *testing.T) {
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := newS3ObjectStore(ServiceConfig{S3Endpoint: server.URL, S3Region: "us-east-1", S3PathStyle: true,
+		S3AccessKey: "access", S3SecretKey: "secret", S3PartSize: 5, S3Concurrency: 3, S3MaxRetries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 12 MB is three 5 MB parts
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12*1024*1024/16)
+	source := filepath.Join(t.TempDir(), "source")
+	_ = os.WriteFile(source, data, 0644)
+
+	sums, _ := fileChecksums(source, true)
+	opts := sums.putOptions()
+	opts.ContentType = "image/jp2"
+	opts.Tags = map[string]string{"lifecycle": "derivative"}
+	err = store.PutFromFile("bucket", "coll/item.jp2", source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the multipart upload is created with the object headers
+	h := fake.headers["bucket/coll/item.jp2"]
+	if h.Get("Content-Type") != "image/jp2" || h.Get("X-Amz-Tagging") != "lifecycle=derivative" {
+		t.Fatalf("unexpected initiate headers %v", h)
+	}
+	if bytes.Equal(fake.objects["bucket/coll/item.jp2"], data) == false {
+		t.Fatal("uploaded object does not match")
+	}
+	if fake.metadata["bucket/coll/item.jp2"] != sums.SHA256 {
+		t.Fatalf("expected sha256 metadata %s, got '%s'", sums.SHA256, fake.metadata["bucket/coll/item.jp2"])
+	}
+	// part 2 was retried on its own
+	parts := 0
+	for _, r := range fake.requests {
+		if strings.HasPrefix(r, "part") {
+			parts++
+		}
+	}
+	if parts != 3 {
+		t.Fatalf("expected 3 parts, got %v", fake.requests)
+	}
+
+	target := filepath.Join(t.TempDir(), "target")
+	err = store.GetToFile("bucket", "coll/item.jp2", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, _ := os.ReadFile(target)
+	if bytes.Equal(downloaded, data) == false {
+		t.Fatal("downloaded object does not match")
+	}
+	ranges := 0
+	for _, r := range fake.requests {
+		if strings.HasPrefix(r, "range") {
+			ranges++
+		}
+	}
+	if ranges != 3 {
+		t.Fatalf("expected 3 ranged GETs, got %v", fake.requests)
+	}
+}
+
+func TestS3ChecksummedPut(t *testing.T) {
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := newS3ObjectStore(ServiceConfig{S3Endpoint: server.URL, S3Region: "us-east-1", S3PathStyle: true,
+		S3AccessKey: "access", S3SecretKey: "secret", S3PartSize: 5, S3Concurrency: 1, S3MaxRetries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(t.TempDir(), "source")
+	_ = os.WriteFile(source, []byte("image data"), 0644)
+	sums, _ := fileChecksums(source, true)
+
+	// a small file is a single PUT that S3 verifies against the whole object checksums
+	opts := sums.putOptions()
+	opts.ContentType = "image/jp2"
+	opts.CacheControl = "max-age=86400"
+	opts.StorageClass = "STANDARD_IA"
+	opts.Metadata["source"] = "s3://in/coll/item.tif"
+	opts.Tags = map[string]string{"lifecycle": "derivative", "rule": "default rule"}
+	err = store.PutFromFile("bucket", "coll/item.jp2", source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fake.requests, ",") != "put,put checksum" || fake.metadata["bucket/coll/item.jp2"] != sums.SHA256 {
+		t.Fatalf("expected a checksummed put, got %v %v", fake.requests, fake.metadata)
+	}
+	h := fake.headers["bucket/coll/item.jp2"]
+	if h.Get("Content-Type") != "image/jp2" || h.Get("Cache-Control") != "max-age=86400" ||
+		h.Get("X-Amz-Storage-Class") != "STANDARD_IA" || h.Get("X-Amz-Meta-Source") != "s3://in/coll/item.tif" ||
+		h.Get("X-Amz-Tagging") != "lifecycle=derivative&rule=default+rule" {
+		t.Fatalf("unexpected put headers %v", h)
+	}
+
+	// the wrong checksum is rejected
+	bad := sums
+	bad.SHA256 = strings.Repeat("0", 64)
+	err = store.PutFromFile("bucket", "coll/other.jp2", source, bad.putOptions())
+	if err == nil {
+		t.Fatal("expected a checksum mismatch to fail the upload")
+	}
+	if _, ok := fake.objects["bucket/coll/other.jp2"]; ok == true {
+		t.Fatal("object with a bad checksum was stored")
+	}
+}
+
+func TestS3StreamedOutputChecksums(t *testing.T) {
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := newS3ObjectStore(ServiceConfig{S3Endpoint: server.URL, S3Region: "us-east-1", S3PathStyle: true,
+		S3AccessKey: "access", S3SecretKey: "secret", S3PartSize: 5, S3Concurrency: 1, S3MaxRetries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.objects["in/coll/streamed.tif"] = []byte("image data")
+
+	// the streamed output has its checksums added to the metadata once uploaded
+	p := newTestPipeline(t, func(cfg *ServiceConfig) {
+		cfg.ConvertStdin = "-"
+		cfg.ConvertStdout = "-"
+		cfg.OutputMD5 = true
+		cfg.OutputCacheControl = "max-age=86400"
+	}, func(svc *workerServices) {
+		svc.objects = store
+	})
+	job, _ := p.ingest("in", "coll/streamed.tif", "image data")
+	if job.Outcome != jobCompleted {
+		t.Fatalf("expected job outcome %s, got %s (%s)", jobCompleted, job.Outcome, job.Error)
+	}
+	if string(fake.objects["out/coll/streamed.jp2"]) != "image data" || fake.metadata["out/coll/streamed.jp2"] != testDataSHA256 {
+		t.Fatalf("unexpected output %q with metadata %v", fake.objects["out/coll/streamed.jp2"], fake.metadata)
+	}
+	h := fake.headers["out/coll/streamed.jp2"]
+	if h.Get("X-Amz-Metadata-Directive") != "REPLACE" || h.Get("X-Amz-Meta-Md5") != testDataMD5 ||
+		h.Get("Content-Type") != "image/jp2" || h.Get("Cache-Control") != "max-age=86400" {
+		t.Fatalf("unexpected copy headers %v", h)
+	}
+
+	// an output that does not match its checksum is rejected
+	err = store.UpdateMetadata("out", "coll/streamed.jp2", PutOptions{SHA256: strings.Repeat("0", 64)})
+	if err == nil || strings.Contains(err.Error(), "sha256 mismatch") == false {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+//
+// end of file
+//
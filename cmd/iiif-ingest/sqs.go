@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// the SQS client is only created if an SQS queue is used
+var (
+	sqsOnce sync.Once
+	sqsSvc  awssqs.AWS_SQS
+	sqsErr  error
+)
+
+func sqsClient() (awssqs.AWS_SQS, error) {
+	sqsOnce.Do(func() {
+		sqsSvc, sqsErr = awssqs.NewAwsSqs(awssqs.AwsSqsConfig{MessageBucketName: " "})
+	})
+	return sqsSvc, sqsErr
+}
+
+// sqsQueue adapts an SQS queue to the MessageSource, MessageAcker and MessagePublisher interfaces
+type sqsQueue struct {
+	aws   awssqs.AWS_SQS
+	name  string
+	queue awssqs.QueueHandle
+}
+
+func newSqsQueue(aws awssqs.AWS_SQS, queueName string) (*sqsQueue, error) {
+	queue, err := aws.QueueHandle(queueName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueue{aws: aws, name: queueName, queue: queue}, nil
+}
+
+func (q *sqsQueue) Receive(max uint, wait time.Duration) ([]awssqs.Message, error) {
+	return q.aws.BatchMessageGet(q.queue, max, wait)
+}
+
+func (q *sqsQueue) Ready() error {
+	_, err := q.aws.QueueHandle(q.name)
+	return err
+}
+
+func (q *sqsQueue) Ack(receiptHandle awssqs.ReceiptHandle) error {
+
+	delMessages := make([]awssqs.Message, 0, 1)
+	delMessages = append(delMessages, awssqs.Message{ReceiptHandle: receiptHandle})
+	opStatus, err := q.aws.BatchMessageDelete(q.queue, delMessages)
+	if err != nil {
+		if err != awssqs.ErrOneOrMoreOperationsUnsuccessful {
+			return err
+		}
+	}
+
+	// check the operation results
+	for ix, op := range opStatus {
+		if op == false {
+			slog.Warn("message failed to delete", "queue", q.name, "index", ix)
+		}
+	}
+
+	// basically everything OK
+	return nil
+}
+
+func (q *sqsQueue) Publish(payload []byte, attributes map[string]string) error {
+
+	attribs := make(awssqs.Attributes, 0, len(attributes))
+	for k, v := range attributes {
+		attribs = append(attribs, awssqs.Attribute{Name: k, Value: v})
+	}
+
+	messages := []awssqs.Message{{Attribs: attribs, Payload: payload}}
+	opStatus, err := q.aws.BatchMessagePut(q.queue, messages)
+	if err != nil {
+		if err != awssqs.ErrOneOrMoreOperationsUnsuccessful {
+			return err
+		}
+		return q.aws.MessagePutRetry(q.queue, messages, opStatus, 3)
+	}
+	return nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// converters that can read from stdin and/or write to stdout (e.g. ImageMagick '-' or vips) can have the source
+// streamed from the object store and the output streamed to the output bucket, so a worker does not need work
+// directory space for them
+
+// the result of a streamed conversion
+type streamResult struct {
+	workFile       string           // the converted file when the output is not streamed
+	sourceSize     int64            // the size of the streamed source
+	sourceChecksum string           // the checksum of the streamed source (when requested)
+	outputSize     int64            // the size of the streamed output
+	outputSums     contentChecksums // the checksums of the streamed output
+}
+
+// sourceReader records the size and checksum of the source as it is streamed along with any read error, so a
+// failure to read the source is not mistaken for a conversion failure
+type sourceReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+	err  error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.size += int64(n)
+	s.hash.Write(p[:n])
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *sourceReader) checksum() string {
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(s.hash.Sum(nil)))
+}
+
+// countingReader records the number of bytes read
+type countingReader struct {
+	r    io.Reader
+	size int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.size += int64(n)
+	return n, err
+}
+
+// the converter output argument, {suffix} is replaced with the conversion suffix (e.g. 'jp2:-' for ImageMagick
+// or '.{suffix}' for vips)
+func streamOutputArg(config ServiceConfig, rule *RoutingRule) string {
+	return strings.ReplaceAll(config.ConvertStdout, "{suffix}", rule.ConvertSuffix)
+}
+
+// convert with the source streamed from the object store and/or the output streamed to the output bucket with
+// the specified upload options. An empty input file streams the source and an empty output bucket writes the
+// output to a work file. Returns
+// the stage that failed (download, convert or upload) along with the error. A failed conversion never leaves
+// a partial output object
+func streamConvert(log *slog.Logger, config ServiceConfig, objects ObjectStore, rule *RoutingRule, notify Notify,
+	inputFile string, outputBucket string, outputKey string, opts PutOptions, checksum bool) (streamResult, string, error) {
+
+	var res streamResult
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the converter input
+	input := inputFile
+	var source *sourceReader
+	if len(inputFile) == 0 {
+		body, err := objects.GetReader(notify.SourceBucket, notify.BucketKey)
+		if err != nil {
+			log.Error("failed to open source object", "error", err)
+			return res, "download", err
+		}
+		defer body.Close()
+		source = &sourceReader{r: body, hash: sha256.New()}
+		input = config.ConvertStdin
+	} else {
+		// the downloaded file is not needed once converted, ignore any errors
+		defer os.Remove(inputFile)
+	}
+
+	// the converter output
+	output := streamOutputArg(config, rule)
+	if len(outputBucket) == 0 {
+		f, err := os.CreateTemp(config.LocalWorkDir, fmt.Sprintf("*.%s", rule.ConvertSuffix))
+		if err != nil {
+			return res, "convert", err
+		}
+		_ = f.Close()
+		output = f.Name()
+		res.workFile = output
+	}
+
+	cmd, err := convertCommand(ctx, log, config, rule, notify.BucketKey, input, output)
+	if err != nil {
+		return res, "convert", err
+	}
+	var diagnostics bytes.Buffer
+	cmd.Stderr = &diagnostics
+	cmd.Stdout = &diagnostics
+	if source != nil {
+		cmd.Stdin = source
+	}
+
+	// the output is uploaded as it is produced
+	var pw *io.PipeWriter
+	var uploaded chan error
+	var counter *countingReader
+	var sums *checksumWriter
+	if len(outputBucket) != 0 {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		cmd.Stdout = pw
+		sums = newChecksumWriter(config.OutputMD5)
+		counter = &countingReader{r: io.TeeReader(pr, sums)}
+		uploaded = make(chan error, 1)
+		go func() {
+			// the checksums are only known once the output is complete, too late to send with the upload
+			err := objects.PutFromReader(outputBucket, outputKey, counter, opts)
+			if err != nil {
+				// the converter must not be left blocked writing output that nobody is reading, cancel first so
+				// the converter failure is attributed to the upload
+				cancel()
+				_ = pr.CloseWithError(err)
+			}
+			uploaded <- err
+		}()
+	}
+
+	log.Debug("convert command", "command", cmd.String(), "stdin", source != nil, "stdout", pw != nil)
+	start := time.Now()
+	err = cmd.Run()
+	stopped := ctx.Err() != nil
+
+	// the converter may not read to the end of the source, the checksum must cover all of it
+	if err == nil && source != nil && checksum == true {
+		_, _ = io.Copy(io.Discard, source)
+	}
+	if source != nil && source.err != nil {
+		err = source.err
+	}
+
+	// complete the upload, closing with an error abandons it
+	var uploadErr error
+	if pw != nil {
+		_ = pw.CloseWithError(err)
+		uploadErr = <-uploaded
+		res.outputSize = counter.size
+		res.outputSums = sums.sums()
+	}
+
+	stage := ""
+	switch {
+	case source != nil && source.err != nil:
+		stage = "download"
+		log.Error("failed to stream source object", "error", err)
+	case uploadErr != nil && (err == nil || stopped == true):
+		stage = "upload"
+		err = uploadErr
+		log.Error("failed to upload", "to", fmt.Sprintf("s3://%s/%s", outputBucket, outputKey), "error", err)
+	case err != nil:
+		stage = "convert"
+		log.Error("conversion failed", "error", err, "output", diagnostics.String())
+	}
+	if err != nil {
+		if len(res.workFile) != 0 {
+			_ = os.Remove(res.workFile)
+		}
+		return res, stage, err
+	}
+
+	log.Info("conversion complete", "seconds", time.Since(start).Seconds())
+	if diagnostics.Len() != 0 {
+		log.Debug("conversion output", "output", diagnostics.String())
+	}
+
+	if source != nil {
+		res.sourceSize = source.size
+		if checksum == true {
+			res.sourceChecksum = source.checksum()
+		}
+	}
+	return res, "", nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// failingUploadStore fails streamed uploads part way through
+type failingUploadStore struct {
+	*memObjectStore
+}
+
+func (f failingUploadStore) PutFromReader(bucket string, key string, r io.Reader, opts PutOptions) error {
+	_, _ = io.ReadFull(r, make([]byte, 4))
+	return errors.New("connection reset")
+}
+
+func TestStreamConvertUploadFailure(t *testing.T) {
+
+	cfg := testConfig(t, func(cfg *ServiceConfig) {
+		cfg.ConvertStdin = "-"
+		cfg.ConvertStdout = "-"
+	})
+	objects := failingUploadStore{newMemObjectStore()}
+	objects.put("in", "coll/item.tif", []byte("image data"))
+	notify := Notify{SourceBucket: "in", BucketKey: "coll/item.tif"}
+
+	_, stage, err := streamConvert(slog.Default(), cfg, objects, &cfg.Rules[0], notify, "", "out", "coll/item.jp2", PutOptions{}, false)
+	if err == nil || stage != "upload" {
+		t.Fatalf("expected an upload failure, got '%s' (%v)", stage, err)
+	}
+	if _, ok := objects.get("out", "coll/item.jp2"); ok == true {
+		t.Fatal("expected no output object")
+	}
+}
+
+func TestStreamConvertFileInput(t *testing.T) {
+
+	cfg := testConfig(t, func(cfg *ServiceConfig) {
+		cfg.ConvertStdout = "-"
+	})
+	objects := newMemObjectStore()
+	input := t.TempDir() + "/input"
+	err := writeLocalFile(input, []byte("image data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := Notify{SourceBucket: "in", BucketKey: "coll/item.tif"}
+
+	res, _, err := streamConvert(slog.Default(), cfg, objects, &cfg.Rules[0], notify, input, "out", "coll/item.jp2", PutOptions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, ok := objects.get("out", "coll/item.jp2")
+	if ok == false || string(o.data) != "image data" || res.outputSize != 10 {
+		t.Fatalf("unexpected output %+v", res)
+	}
+	if fileSize(input) != 0 {
+		t.Fatal("expected the input file to be removed")
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the tracer for the ingest pipeline; a no-op until tracing is initialized
+var tracer = otel.Tracer("github.com/uvalib/iiif-ingest")
+
+// the trace context propagator, used to extract the trace context from inbound message attributes
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// initialize tracing with the specified exporter (none, otlp or stdout). The OTLP exporter is configured using the
+// standard OTEL_EXPORTER_OTLP_* environment variables
+func initTracing(exporter string) error {
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporter) {
+	case "", "none":
+		return nil
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return fmt.Errorf("unsupported trace exporter '%s'", exporter)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("iiif-ingest"),
+		semconv.ServiceVersion(Version())))
+	if err != nil {
+		return err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	tracer = provider.Tracer("github.com/uvalib/iiif-ingest")
+	return nil
+}
+
+// the message attributes, used to carry the trace context from the uploading application
+func messageAttributes(message awssqs.Message) map[string]string {
+	attribs := make(map[string]string, len(message.Attribs))
+	for _, a := range message.Attribs {
+		attribs[a.Name] = a.Value
+	}
+	return attribs
+}
+
+// start the job span, a child of any trace context carried by the inbound message
+func startJobSpan(notify Notify) (context.Context, trace.Span) {
+
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier(notify.MessageAttributes))
+	ctx, span := tracer.Start(ctx, "ingest", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("iiif.source.bucket", notify.SourceBucket),
+			attribute.String("iiif.source.key", notify.BucketKey),
+			attribute.Int64("iiif.source.size", notify.ExpectedSize)))
+
+	// the receive span covers the time the message waited for a worker
+	if notify.ReceivedAt.IsZero() == false {
+		_, receive := tracer.Start(ctx, "receive", trace.WithTimestamp(notify.ReceivedAt))
+		receive.End()
+	}
+	return ctx, span
+}
+
+// start a pipeline stage; the returned logger carries the stage name and the span is a child of the job span
+func startStage(ctx context.Context, logger *slog.Logger, name string) (context.Context, *slog.Logger, trace.Span) {
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, logger.With("stage", name), span
+}
+
+// end a span, recording any error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// the trace id of the span (if we are tracing) for log correlation
+func traceId(span trace.Span) string {
+	sc := span.SpanContext()
+	if sc.HasTraceID() == false {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+//
+// end of file
+//
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestJobSpanContinuesMessageTrace(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	saved := tracer
+	tracer = provider.Tracer("test")
+	defer func() { tracer = saved }()
+
+	// the uploading application's trace context arrives as SQS message attributes
+	event := `{"Records":[{"s3":{"bucket":{"name":"in"},"object":{"key":"coll/item01.tif","size":10}}}]}`
+	message := awssqs.Message{ReceiptHandle: "receipt-1", Payload: []byte(event), Attribs: awssqs.Attributes{
+		{Name: "traceparent", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}}
+	inbound, err := decodeInboundFile(message)
+	if err != nil || inbound == nil {
+		t.Fatalf("unexpected inbound file %+v (%v)", inbound, err)
+	}
+
+	_, span := startJobSpan(inbound.notify(message.ReceiptHandle))
+	span.End()
+
+	if traceId(span) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the message trace, got '%s'", traceId(span))
+	}
+	spans := recorder.Ended()
+	var job sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == "ingest" {
+			job = s
+		}
+	}
+	if job == nil {
+		t.Fatalf("expected an ingest span, got %d spans", len(spans))
+	}
+	if job.Parent().SpanID().String() != "00f067aa0ba902b7" || job.Parent().IsRemote() == false ||
+		job.SpanKind() != trace.SpanKindConsumer {
+		t.Fatalf("expected a consumer span with the remote parent, got parent %+v kind %s", job.Parent(), job.SpanKind())
+	}
+
+	// the receive span is a child of the job span
+	for _, s := range spans {
+		if s.Name() == "receive" && s.Parent().SpanID() != job.SpanContext().SpanID() {
+			t.Fatalf("expected the receive span to be a child of the job span")
+		}
+	}
+
+	// without a trace context the job starts a new trace
+	message.Attribs = nil
+	inbound, _ = decodeInboundFile(message)
+	_, span = startJobSpan(inbound.notify(message.ReceiptHandle))
+	span.End()
+	if traceId(span) == "" || traceId(span) == "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected a new trace, got '%s'", traceId(span))
+	}
+}
+
+//
+// end of file
+//
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
 	"time"
 
-	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
+	"github.com/google/uuid"
 	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Notify - our worker notification structure
@@ -18,154 +20,374 @@ type Notify struct {
 	SourceBucket  string               // the bucket name
 	BucketKey     string               // the bucket key (file name)
 	ExpectedSize  int64                // the expected size of the object
+	ETag          string               // the object ETag
 	ReceiptHandle awssqs.ReceiptHandle // the inbound message receipt handle (so we can delete it)
+
+	MessageAttributes map[string]string // the inbound message attributes (may carry the trace context)
+	ReceivedAt        time.Time         // when the inbound message was received
+}
+
+// the services used by the workers
+type workerServices struct {
+	objects ObjectStore  // the object store
+	acker   MessageAcker // acknowledges processed messages
+	jobs    JobStore     // the job history
+	audit   AuditLog     // the audit log of source removals
+	events  EventEmitter // the failure and completion events
+	pool    *workerPool  // limits how many jobs run at once
+	disk    *diskBudget  // admits jobs to the work directory
 }
 
-func worker(workerId int, config ServiceConfig, sqsSvc awssqs.AWS_SQS, s3Svc uva_s3.UvaS3, queue awssqs.QueueHandle, notifies <-chan Notify) {
+func worker(workerId int, lane int, holder *configHolder, svc workerServices, notifies <-chan Notify) {
 
 	var notify Notify
 	for {
+		// wait until the worker pool allows another job to start in this lane, so a notification is never
+		// taken by a worker that cannot start it
+		svc.pool.acquire(lane)
+
 		// wait for an inbound file
 		notify = <-notifies
+		svc.pool.begin(lane)
+
+		// the configuration for this job, unaffected by any subsequent reload
+		config := holder.Get()
+
+		// every log line for this job carries the job correlation attributes
+		job := JobRecord{Id: uuid.NewString(), SourceBucket: notify.SourceBucket, SourceKey: notify.BucketKey,
+			SourceSize: notify.ExpectedSize, Started: time.Now()}
+		ctx, span := startJobSpan(notify)
+		logger := newJobLogger(workerId, job.Id, notify)
+		if id := traceId(span); len(id) != 0 {
+			logger = logger.With("trace", id)
+		}
 
-		start := time.Now()
-		log.Printf("[worker %d] INFO: begin processing %s", workerId, notify.BucketKey)
-
-		// validate the inbound file naming convention
-		err := validateInputName(workerId, config, notify.BucketKey)
-		if err != nil {
-			log.Printf("[worker %d] ERROR: input name %s is invalid (%s)", workerId, notify.BucketKey, err.Error())
+		workersBusy.Inc()
+		activity.begin(workerId)
+		logger.Info("begin processing", "stage", "receive")
+
+		err := processNotification(ctx, logger, config, svc, notify, &job)
+		activity.end(workerId)
+		svc.pool.release(lane)
+		workersBusy.Dec()
+		endSpan(span, err)
+		recordJob(logger, svc.jobs, &job, err)
+		switch job.Outcome {
+		case jobDeferred, jobRetried:
+			messageCount(notify.BucketKey, outcomeDeferred)
+			continue
+		case jobFailed:
+			messageCount(notify.BucketKey, outcomeFailed)
 			continue
 		}
+		messageCount(notify.BucketKey, outcomeCompleted)
 
-		// create the output file name
-		outputFile := generateOutputName(workerId, config, notify.BucketKey)
+		duration := job.Finished.Sub(job.Started)
+		logger.Info("processing complete", "stage", "complete", "seconds", duration.Seconds())
+	}
+
+	// should never get here
+}
 
-		// create the target directory tree if we are outputting to a local filesystem
-		if len(config.OutputFSRoot) != 0 {
-			fullOutputFile := fmt.Sprintf("%s/%s", config.OutputFSRoot, outputFile)
-			err = createOutputDirectory(workerId, fullOutputFile)
+// record the job outcome in the job store
+func recordJob(logger *slog.Logger, jobs JobStore, job *JobRecord, err error) {
+	job.Finished = time.Now()
+	job.Outcome = jobOutcome(err)
+	if err != nil {
+		job.Error = err.Error()
+	}
+	e := jobs.Record(job)
+	if e != nil {
+		logger.Warn("failed to record job", "error", e)
+	}
+}
+
+// process a single inbound notification, recording the job details as we go. Any errors have already been logged
+func processNotification(ctx context.Context, logger *slog.Logger, config ServiceConfig, svc workerServices, notify Notify, job *JobRecord) error {
+
+	// the source details for the audit record should the source be removed
+	source := DeletionRecord{Job: job.Id, SourceBucket: notify.SourceBucket, SourceKey: notify.BucketKey,
+		SourceETag: notify.ETag, SourceSize: notify.ExpectedSize}
+
+	// a source object that cannot be processed is moved to quarantine (if configured) so it is not redelivered
+	// indefinitely and a failure event is emitted. Without quarantine the message of a permanent failure (one
+	// that redelivery cannot fix) is deleted, otherwise it is redelivered
+	failed := func(stage string, reason error, permanent bool) {
+		ev := Event{Type: eventFailed, Time: time.Now(), Job: job.Id, Stage: stage, SourceBucket: notify.SourceBucket,
+			SourceKey: notify.BucketKey, SourceETag: notify.ETag, SourceSize: source.SourceSize, Reason: reason.Error()}
+		if config.QuarantineDestination.isSet() == true {
+			_, log, span := startStage(ctx, logger, "quarantine")
+			rec := source
+			rec.Stage = stage
+			err := quarantineSource(log, svc.objects, svc.audit, config.QuarantineDestination, rec, reason)
+			if err == nil {
+				ev.Quarantine = config.QuarantineDestination.location(config.QuarantineDestination.outputName(notify.BucketKey))
+				err = deleteMessage(log, svc.acker, notify.ReceiptHandle)
+			}
+			endSpan(span, err)
+		} else if permanent == true {
+			err := deleteMessage(logger, svc.acker, notify.ReceiptHandle)
 			if err != nil {
-				continue
+				logger.Error("failed to delete an unprocessable message", "error", err)
 			}
 		}
+		err := svc.events.Emit(ctx, ev)
+		if err != nil {
+			logger.Warn("failed to emit event", "type", ev.Type, "error", err)
+		}
+	}
+
+	// validate the inbound file naming convention
+	_, log, span := startStage(ctx, logger, "validate")
+	rule, err := validateInputName(log, config, notify.SourceBucket, notify.BucketKey)
+	if err != nil {
+		log.Error("input name is invalid", "error", err)
+		endSpan(span, err)
+		failed("validate", err, true)
+		return err
+	}
+
+	// determine where the output goes
+	dest, err := resolveDestination(config, rule, notify.SourceBucket, notify.BucketKey)
+	if err != nil {
+		log.Error("no output destination", "error", err)
+		endSpan(span, err)
+		// a missing route is a configuration fault so the message is redelivered once it is fixed
+		failed("route", err, false)
+		return err
+	}
+	log.Debug("output destination selected", "destination", dest.String())
+
+	// create the output file name, the output may also be written to additional targets
+	outputName := generateOutputName(log, rule, notify.BucketKey)
+	outputFile := config.targetName(dest, outputName)
+	targets := outputTargets(config, dest)
+	logger = logger.With("output", outputFile)
+	span.SetAttributes(attribute.String("iiif.rule", rule.Name), attribute.String("iiif.output", outputFile))
+	job.Rule = rule.Name
+	job.Output = dest.location(outputFile)
+	job.Options, _ = rule.convertOptions(config, path.Ext(notify.BucketKey))
+	job.OptionsHash = optionsHash(config.ConvertBinary, job.Options, rule.ConvertSuffix)
+	endSpan(span, nil)
+
+	// the source and/or output may be streamed through the converter rather than kept in the work directory.
+	// Output to a local filesystem or to more than one destination is always converted to a work file first
+	streamInput := len(config.ConvertStdin) != 0
+	streamOutput := len(config.ConvertStdout) != 0 && len(dest.FSRoot) == 0 && len(targets) == 1
+
+	// reserve the work directory space the job needs, a job that cannot get it is deferred and the message
+	// is redelivered later
+	_, log, span = startStage(ctx, logger, "reserve")
+	if job.SourceSize <= 0 {
+		// the event did not include the size so ask the store, otherwise nothing would be reserved
+		job.SourceSize, err = svc.objects.StatObject(notify.SourceBucket, notify.BucketKey)
+		if err != nil {
+			log.Error("failed to determine the source size", "error", err)
+			endSpan(span, err)
+			return err
+		}
+		source.SourceSize = job.SourceSize
+	}
+	estimate := diskEstimate(config, notify.BucketKey, job.SourceSize, streamInput)
+	reservation, err := svc.disk.reserve(config.LocalWorkDir, estimate, uint64(config.MinFreeSpace)*1024*1024,
+		time.Duration(config.DiskWait)*time.Second)
+	endSpan(span, err)
+	if err != nil {
+		log.Warn("job deferred", "error", err)
+		return fmt.Errorf("%w: %s", errJobDeferred, err.Error())
+	}
+	defer reservation.release()
+	log.Debug("work directory space reserved", "bytes", estimate)
 
+	downloadFile := ""
+	if streamInput == false {
 		// create temp file
+		_, log, span = startStage(ctx, logger, "download")
 		tmp, err := os.CreateTemp(config.LocalWorkDir, "*")
 		if err != nil {
-			log.Printf("[worker %d] ERROR: failed to create temp file (%s)", workerId, err.Error())
-			continue
+			log.Error("failed to create temp file", "error", err)
+			endSpan(span, err)
+			return err
 		}
 
 		_ = tmp.Close()
-		downloadFile := tmp.Name()
+		downloadFile = tmp.Name()
 
 		// download the file
-		o := uva_s3.NewUvaS3Object(notify.SourceBucket, notify.BucketKey)
-		err = s3Svc.GetToFile(o, downloadFile)
+		timer := newStageTimer(downloadDuration, notify.BucketKey)
+		err = svc.objects.GetToFile(notify.SourceBucket, notify.BucketKey, downloadFile)
+		job.DownloadSeconds = timer.observe(err).Seconds()
+		endSpan(span, err)
 		if err != nil {
-			log.Printf("[worker %d] ERROR: failed to download %s (%s)", workerId, notify.BucketKey, err.Error())
-			continue
+			observeSize(downloadBytes, notify.BucketKey, notify.ExpectedSize, err)
+			log.Error("failed to download", "error", err)
+			_ = os.Remove(downloadFile)
+			return err
 		}
+		downloadSize := fileSize(downloadFile)
+		observeSize(downloadBytes, notify.BucketKey, downloadSize, nil)
 
-		// convert the file
-		workFile, err := convertFile(workerId, config, notify.BucketKey, downloadFile)
-		if err != nil {
-			continue
+		// if the source may be removed we need the details for the audit record
+		source.SourceSize = downloadSize
+		if config.auditRequired() == true {
+			source.SourceChecksum, err = fileChecksum(downloadFile)
+			if err != nil {
+				log.Error("failed to checksum downloaded file", "error", err)
+				_ = os.Remove(downloadFile)
+				return err
+			}
 		}
+	}
 
-		// if we are outputting to a local filesystem
-		if len(config.OutputFSRoot) != 0 {
-			fullOutputFile := fmt.Sprintf("%s/%s", config.OutputFSRoot, outputFile)
-			// copy the file to the correct location and delete the original
-			err = copyFile(workerId, workFile, fullOutputFile)
-			_ = os.Remove(workFile)
-			if err != nil {
-				log.Printf("[worker %d] ERROR: failed to copy %s to %s (%s)", workerId, workFile, outputFile, err.Error())
-				continue
+	// convert the file
+	_, log, span = startStage(ctx, logger, "convert")
+	timer := newStageTimer(conversionDuration, notify.BucketKey)
+	var workFile string
+	var outputSize int64
+	var outputSums contentChecksums
+	if streamInput == true || streamOutput == true {
+		outputBucket := ""
+		if streamOutput == true {
+			outputBucket = dest.Bucket
+		}
+		opts := outputPutOptions(config, rule, notify, job, contentChecksums{})
+		res, stage, err := streamConvert(log, config, svc.objects, rule, notify, downloadFile, outputBucket, outputFile, opts, config.auditRequired())
+		job.ConvertSeconds = timer.observe(err).Seconds()
+		endSpan(span, err)
+		if streamInput == true {
+			observeSize(downloadBytes, notify.BucketKey, res.sourceSize, err)
+			source.SourceSize = res.sourceSize
+			source.SourceChecksum = res.sourceChecksum
+		}
+		if streamOutput == true {
+			observeSize(uploadBytes, notify.BucketKey, res.outputSize, err)
+			outputSize = res.outputSize
+			outputSums = res.outputSums
+		}
+		if err != nil {
+			if stage == "convert" {
+				err = convertFailed(log, svc.pool, err, failed)
 			}
-		} else {
-			// we are outputting to a bucket
-			o := uva_s3.NewUvaS3Object(config.OutputBucket, outputFile)
-			err := s3Svc.PutFromFile(o, workFile)
-			_ = os.Remove(workFile)
+			return err
+		}
+		workFile = res.workFile
+
+		// the checksums of a streamed output are only known once it is uploaded so they are added to its
+		// metadata afterwards, an output that fails verification is removed
+		if streamOutput == true {
+			_, log, span = startStage(ctx, logger, "checksum")
+			err = svc.objects.UpdateMetadata(dest.Bucket, outputFile, outputPutOptions(config, rule, notify, job, outputSums))
+			endSpan(span, err)
 			if err != nil {
-				log.Printf("[worker %d] ERROR: failed to upload %s to s3://%s/%s (%s)", workerId, workFile, config.OutputBucket, outputFile, err.Error())
-				continue
+				log.Error("failed to record output checksums", "error", err)
+				_ = svc.objects.DeleteObject(dest.Bucket, outputFile)
+				return err
 			}
 		}
+	} else {
+		workFile, err = convertFile(log, config, rule, notify.BucketKey, downloadFile)
+		job.ConvertSeconds = timer.observe(err).Seconds()
+		endSpan(span, err)
+		if err != nil {
+			return convertFailed(log, svc.pool, err, failed)
+		}
+	}
 
-		// should we delete the bucket contents
-		if config.DeleteSource == true {
-			// bucket file has been processed, remove it
-			log.Printf("[worker %d] INFO: removing S3 object %s/%s", workerId, notify.SourceBucket, notify.BucketKey)
-			err = s3Svc.DeleteObject(o)
-			if err != nil {
-				continue
-			}
+	// write the converted file to each destination
+	var written []string
+	if streamOutput == false {
+		outputSize = fileSize(workFile)
+		_, log, span = startStage(ctx, logger, "upload")
+		timer = newStageTimer(uploadDuration, notify.BucketKey)
+		outputSums, err = fileChecksums(workFile, config.OutputMD5)
+		if err == nil {
+			out := convertedOutput{name: outputName, workFile: workFile,
+				opts: outputPutOptions(config, rule, notify, job, outputSums),
+				sidecar: OutputSidecar{Job: job.Id, Time: time.Now(), SourceBucket: notify.SourceBucket,
+					SourceKey: notify.BucketKey, Size: outputSize, SHA256: outputSums.SHA256, MD5: outputSums.MD5}}
+			written, err = writeOutputs(log, config, svc.objects, targets, out)
+		} else {
+			log.Error("failed to checksum converted file", "error", err)
+		}
+		_ = os.Remove(workFile)
+		job.UploadSeconds = timer.observe(err).Seconds()
+		observeSize(uploadBytes, notify.BucketKey, outputSize, err)
+		endSpan(span, err)
+		if err != nil {
+			return err
 		}
+	} else {
+		written = []string{job.Output}
+	}
+	log.Debug("output checksums", "sha256", outputSums.SHA256, "md5", outputSums.MD5)
 
-		// delete the inbound message
-		err = deleteMessage(workerId, sqsSvc, queue, notify.ReceiptHandle)
+	// what happens to the bucket contents
+	if config.SourceDisposition != dispositionNone {
+		_, log, span = startStage(ctx, logger, "dispose-source")
+		err = disposeSource(log, config, svc.objects, svc.audit, requiredOutputs(config, targets, outputName), source)
+		endSpan(span, err)
 		if err != nil {
-			log.Printf("[worker %d] ERROR: failed to delete a processed message (%s)", workerId, err.Error())
-			continue
+			return err
 		}
+	}
 
-		duration := time.Since(start)
-		log.Printf("[worker %d] INFO: processing %s complete in %0.2f seconds", workerId, notify.BucketKey, duration.Seconds())
+	// delete the inbound message
+	_, log, span = startStage(ctx, logger, "delete-message")
+	err = deleteMessage(log, svc.acker, notify.ReceiptHandle)
+	endSpan(span, err)
+	if err != nil {
+		log.Error("failed to delete a processed message", "error", err)
+		return err
 	}
 
-	// should never get here
+	if config.CompletionEvents == true {
+		ev := Event{Type: eventCompleted, Time: time.Now(), Job: job.Id, SourceBucket: notify.SourceBucket,
+			SourceKey: notify.BucketKey, SourceETag: notify.ETag, SourceSize: source.SourceSize, Output: job.Output,
+			Outputs: written, OutputSize: outputSize, OutputSHA256: outputSums.SHA256, OutputMD5: outputSums.MD5}
+		err = svc.events.Emit(ctx, ev)
+		if err != nil {
+			logger.Warn("failed to emit event", "type", ev.Type, "error", err)
+		}
+	}
+
+	return nil
 }
 
-func convertFile(workerId int, config ServiceConfig, bucketKey string, inputFile string) (string, error) {
+// handle a conversion failure. A master that cannot be converted will not convert next time either, unless
+// the converter was killed (normally for lack of memory) in which case it is retried with fewer workers.
+// Returns the error the job finishes with
+func convertFailed(log *slog.Logger, pool *workerPool, err error, failed func(stage string, reason error, permanent bool)) error {
+	if converterWasKilled(err) == true {
+		log.Warn("converter was killed, the job will be retried")
+		pool.converterKilled()
+		return fmt.Errorf("%w: %s", errJobRetried, err.Error())
+	}
+	failed("convert", err, false)
+	return err
+}
+
+func convertFile(log *slog.Logger, config ServiceConfig, rule *RoutingRule, bucketKey string, inputFile string) (string, error) {
 
 	// create a temp file
-	f, err := os.CreateTemp(config.LocalWorkDir, fmt.Sprintf("*.%s", config.ConvertSuffix))
+	f, err := os.CreateTemp(config.LocalWorkDir, fmt.Sprintf("*.%s", rule.ConvertSuffix))
 	if err != nil {
 		return "", err
 	}
 	_ = f.Close()
 	outputFile := f.Name()
 
-	// determine the convert options
-	fileExt := path.Ext(bucketKey)
-	options, ok := config.ConvertOptions[fileExt]
-	if ok == true {
-		log.Printf("[worker %d] DEBUG: using custom conversion options for [%s] files", workerId, fileExt)
-	} else {
-		log.Printf("[worker %d] DEBUG: no custom conversion options, using default ones", workerId)
-		options, _ = config.ConvertOptions["*"]
-	}
-
 	// do the conversion
-	params := strings.Split(options, " ")
-	var cmd *exec.Cmd
-	switch len(params) {
-	case 0:
-		cmd = exec.Command(config.ConvertBinary, inputFile, outputFile)
-	case 1:
-		cmd = exec.Command(config.ConvertBinary, inputFile, params[0], outputFile)
-	case 2:
-		cmd = exec.Command(config.ConvertBinary, inputFile, params[0], params[1], outputFile)
-	case 3:
-		cmd = exec.Command(config.ConvertBinary, inputFile, params[0], params[1], params[2], outputFile)
-	case 4:
-		cmd = exec.Command(config.ConvertBinary, inputFile, params[0], params[1], params[2], params[3], outputFile)
-	case 5:
-		cmd = exec.Command(config.ConvertBinary, inputFile, params[0], params[1], params[2], params[3], params[4], outputFile)
-	default:
-		fatalIfError(fmt.Errorf("excessive command options (%d), update code", len(params)))
+	cmd, err := convertCommand(context.Background(), log, config, rule, bucketKey, inputFile, outputFile)
+	if err != nil {
+		_ = os.Remove(inputFile)
+		_ = os.Remove(outputFile)
+		return "", err
 	}
-	log.Printf("[worker %d] DEBUG: convert command \"%s\"", workerId, cmd.String())
+	log.Debug("convert command", "command", cmd.String())
 	start := time.Now()
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("[worker %d] ERROR: processing %s (%s)", workerId, bucketKey, err.Error())
-		if len(output) != 0 {
-			log.Printf("[worker %d] ERROR: conversion output [%s]", workerId, output)
-		}
+		log.Error("conversion failed", "error", err, "output", string(output))
 		// remove the input and output files and ignore any errors
 		_ = os.Remove(inputFile)
 		_ = os.Remove(outputFile)
@@ -176,43 +398,65 @@ func convertFile(workerId int, config ServiceConfig, bucketKey string, inputFile
 
 	// cleanup and return
 	duration := time.Since(start)
-	log.Printf("[worker %d] INFO: conversion complete in %0.2f seconds", workerId, duration.Seconds())
+	log.Info("conversion complete", "seconds", duration.Seconds())
 
 	// if we have some output, log it
 	if len(output) != 0 {
-		log.Printf("[worker %d] DEBUG: conversion output [%s]", workerId, output)
+		log.Debug("conversion output", "output", string(output))
 	}
 
 	// original file has been converted, remove it and ignore any errors
-	log.Printf("[worker %d] INFO: removing downloaded file %s", workerId, inputFile)
+	log.Info("removing downloaded file", "file", inputFile)
 	_ = os.Remove(inputFile)
 
 	// all good
 	return outputFile, nil
 }
 
-func deleteMessage(workerId int, aws awssqs.AWS_SQS, queue awssqs.QueueHandle, receiptHandle awssqs.ReceiptHandle) error {
+// the most conversion parameters the conversion command supports
+var maxConvertParams = 5
 
-	log.Printf("[worker %d] INFO: deleting queue message", workerId)
+// the number of parameters in the conversion options
+func convertParams(options string) int {
+	return len(strings.Split(options, " "))
+}
 
-	delMessages := make([]awssqs.Message, 0, 1)
-	delMessages = append(delMessages, awssqs.Message{ReceiptHandle: receiptHandle})
-	opStatus, err := aws.BatchMessageDelete(queue, delMessages)
-	if err != nil {
-		if err != awssqs.ErrOneOrMoreOperationsUnsuccessful {
-			return err
-		}
+// the conversion command; the input and output are file names or the converter stdin and stdout arguments
+func convertCommand(ctx context.Context, log *slog.Logger, config ServiceConfig, rule *RoutingRule, bucketKey string, input string, output string) (*exec.Cmd, error) {
+
+	// determine the convert options
+	fileExt := path.Ext(bucketKey)
+	options, custom := rule.convertOptions(config, fileExt)
+	if custom == true {
+		log.Debug("using custom conversion options", "extension", fileExt)
+	} else {
+		log.Debug("no custom conversion options, using default ones")
 	}
 
-	// check the operation results
-	for ix, op := range opStatus {
-		if op == false {
-			log.Printf("[worker %d] WARNING: message %d failed to delete", workerId, ix)
-		}
+	params := strings.Split(options, " ")
+	var cmd *exec.Cmd
+	switch len(params) {
+	case 0:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, output)
+	case 1:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, params[0], output)
+	case 2:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, params[0], params[1], output)
+	case 3:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, params[0], params[1], params[2], output)
+	case 4:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, params[0], params[1], params[2], params[3], output)
+	case 5:
+		cmd = exec.CommandContext(ctx, config.ConvertBinary, input, params[0], params[1], params[2], params[3], params[4], output)
+	default:
+		return nil, fmt.Errorf("excessive command options (%d), at most %d are supported", len(params), maxConvertParams)
 	}
+	return cmd, nil
+}
 
-	// basically everything OK
-	return nil
+func deleteMessage(log *slog.Logger, acker MessageAcker, receiptHandle awssqs.ReceiptHandle) error {
+	log.Info("deleting queue message")
+	return acker.Ack(receiptHandle)
 }
 
 //
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// the stub converter copies the input to the output (the last argument), fails for input containing FAIL and
+// is killed for input containing KILL. An input of '-' reads stdin and an output ending in '-' writes stdout
+var stubConverter = `#!/bin/sh
+in="$1"
+for last; do :; done
+if [ "$in" = "-" ]; then
+	in=$(mktemp)
+	cat > "$in"
+fi
+if grep -q KILL "$in"; then
+	kill -9 $$
+fi
+if grep -q FAIL "$in"; then
+	echo "cannot convert $in" >&2
+	exit 1
+fi
+case "$last" in
+*-) cat "$in" ;;
+*) cp "$in" "$last" ;;
+esac
+`
+
+// the checksums of "image data"
+var testDataSHA256 = "b41b86dcfdc6219bc2fb987591ad9995bcf3a1e40c2bdd3fdbec622371e6e1af"
+var testDataMD5 = "e09a574ca3760a3e28a3e5920fe4627e"
+
+func TestMain(m *testing.M) {
+	// the pipeline logs are not interesting unless debugging
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testPipeline drives a single worker with in-memory services
+type testPipeline struct {
+	t        *testing.T
+	config   ServiceConfig
+	objects  *memObjectStore
+	queue    *memQueue
+	jobs     *memJobStore
+	audit    *memAuditLog
+	events   *memEvents
+	pool     *workerPool
+	disk     *diskBudget
+	notifies chan Notify
+}
+
+// the pipeline configuration with a stub converter, it may be adjusted before use
+func testConfig(t *testing.T, configure func(cfg *ServiceConfig)) ServiceConfig {
+
+	converter := filepath.Join(t.TempDir(), "convert")
+	err := os.WriteFile(converter, []byte(stubConverter), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ServiceConfig{
+		PollTimeOut:       1,
+		LocalWorkDir:      t.TempDir(),
+		WorkerQueueSize:   1,
+		Workers:           1,
+		ConvertBinary:     converter,
+		ConvertSuffix:     "jp2",
+		ConvertOptions:    map[string]string{"*": "-rate 1"},
+		OutputBucket:      "out",
+		SourceDisposition: dispositionNone,
+		ProcessedTag:      defaultProcessedTag,
+		Rules: []RoutingRule{{
+			Name:               "test",
+			InputNameRegex:     `^(\w+)/(\w+)$`,
+			OutputNameTemplate: "{:1}/{:2}",
+			ConvertSuffix:      "jp2",
+		}},
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	for ix := range cfg.Rules {
+		err = cfg.Rules[ix].compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+// create a pipeline, the configuration and services may be adjusted before the worker starts
+func newTestPipeline(t *testing.T, configure func(cfg *ServiceConfig), services func(svc *workerServices)) *testPipeline {
+
+	cfg := testConfig(t, configure)
+	p := &testPipeline{
+		t:        t,
+		config:   cfg,
+		objects:  newMemObjectStore(),
+		queue:    newMemQueue(),
+		jobs:     newMemJobStore(),
+		audit:    &memAuditLog{},
+		events:   &memEvents{},
+		pool:     newWorkerPool([]Lane{{Name: "default", Workers: 1}}, nil),
+		disk:     newDiskBudget(freeSpace),
+		notifies: make(chan Notify, 1),
+	}
+
+	svc := workerServices{objects: p.objects, acker: p.queue, jobs: p.jobs, audit: p.audit, events: p.events,
+		pool: p.pool, disk: p.disk}
+	if services != nil {
+		services(&svc)
+	}
+	go worker(1, 0, newConfigHolder(cfg), svc, p.notifies)
+	return p
+}
+
+// upload an object, deliver the S3 event for it and wait for the worker to finish the job
+func (p *testPipeline) ingest(bucket string, key string, content string) (JobRecord, awssqs.ReceiptHandle) {
+	return p.ingestWithSize(bucket, key, content, len(content))
+}
+
+// as ingest, with the object size reported by the event (0 when the event does not include it)
+func (p *testPipeline) ingestWithSize(bucket string, key string, content string, size int) (JobRecord, awssqs.ReceiptHandle) {
+
+	p.objects.put(bucket, key, []byte(content))
+	event := fmt.Sprintf(`{"Records":[{"s3":{"bucket":{"name":"%s"},"object":{"key":"%s","size":%d,"eTag":"abc123"}}}]}`,
+		bucket, url.QueryEscape(key), size)
+	p.queue.send(event)
+
+	inbound := getInboundNotifications(p.config, p.queue, p.queue, 1)
+	receiptHandle := inbound[0].ReceiptHandle
+	p.notifies <- inbound[0]
+
+	select {
+	case job := <-p.jobs.finished:
+		return job, receiptHandle
+	case <-time.After(10 * time.Second):
+		p.t.Fatal("timed out waiting for the job to finish")
+	}
+	return JobRecord{}, receiptHandle
+}
+
+func (p *testPipeline) mustExist(bucket string, key string) *memObject {
+	o, ok := p.objects.get(bucket, key)
+	if ok == false {
+		p.t.Fatalf("expected s3://%s/%s to exist", bucket, key)
+	}
+	return o
+}
+
+func (p *testPipeline) mustNotExist(bucket string, key string) {
+	_, ok := p.objects.get(bucket, key)
+	if ok == true {
+		p.t.Fatalf("expected s3://%s/%s not to exist", bucket, key)
+	}
+}
+
+// pipelineCase is a single inbound object processed by its own pipeline
+type pipelineCase struct {
+	name      string                                             // the case name
+	key       string                                             // the source key, distinct across all cases
+	content   string                                             // the source contents (image data by default)
+	unsized   bool                                               // the event does not include the object size
+	configure func(t *testing.T, cfg *ServiceConfig)             // adjusts the configuration (optional)
+	services  func(svc *workerServices)                          // adjusts the services (optional)
+	outcome   string                                             // the expected job outcome
+	acked     bool                                               // is the message expected to be acknowledged
+	check     func(t *testing.T, p *testPipeline, job JobRecord) // further checks (optional)
+}
+
+// run each case through its own pipeline
+func runPipelineCases(t *testing.T, cases []pipelineCase) {
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure func(cfg *ServiceConfig)
+			if tt.configure != nil {
+				configure = func(cfg *ServiceConfig) { tt.configure(t, cfg) }
+			}
+			content := tt.content
+			if len(content) == 0 {
+				content = "image data"
+			}
+
+			p := newTestPipeline(t, configure, tt.services)
+			size := len(content)
+			if tt.unsized == true {
+				size = 0
+			}
+			job, rh := p.ingestWithSize("in", tt.key, content, size)
+
+			if job.Outcome != tt.outcome {
+				t.Fatalf("expected job outcome %s, got %s (%s)", tt.outcome, job.Outcome, job.Error)
+			}
+			if p.queue.isAcked(rh) != tt.acked {
+				t.Fatalf("expected message acknowledged %t", tt.acked)
+			}
+			if tt.check != nil {
+				tt.check(t, p, job)
+			}
+		})
+	}
+}
+
+// a file where a directory is expected, so it cannot be used as an output root
+func unwritableRoot(t *testing.T) string {
+	unwritable := filepath.Join(t.TempDir(), "file")
+	_ = os.WriteFile(unwritable, nil, 0644)
+	return unwritable
+}
+
+func TestPipelineOutputs(t *testing.T) {
+
+	runPipelineCases(t, []pipelineCase{
+		{
+			name:    "converts and uploads",
+			key:     "coll/uploads.tif",
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				if job.Rule != "test" || job.Output != "s3://out/coll/uploads.jp2" || job.Options != "-rate 1" || job.Attempts != 1 {
+					t.Fatalf("unexpected job record %+v", job)
+				}
+				o := p.mustExist("out", "coll/uploads.jp2")
+				if string(o.data) != "image data" {
+					t.Fatalf("unexpected output contents %q", o.data)
+				}
+				p.mustExist("in", "coll/uploads.tif")
+			},
+		},
+		{
+			name: "checksums",
+			key:  "coll/checksums.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputMD5 = true
+				cfg.CompletionEvents = true
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				o := p.mustExist("out", "coll/checksums.jp2")
+				if o.opts.SHA256 != testDataSHA256 || o.opts.MD5 != testDataMD5 ||
+					o.opts.Metadata[metadataSHA256] != testDataSHA256 || o.opts.Metadata[metadataMD5] != testDataMD5 {
+					t.Fatalf("unexpected upload options %+v", o.opts)
+				}
+				events := p.events.all()
+				if len(events) != 1 || events[0].Type != eventCompleted || events[0].Output != "s3://out/coll/checksums.jp2" ||
+					events[0].OutputSize != 10 || events[0].OutputSHA256 != testDataSHA256 || events[0].OutputMD5 != testDataMD5 {
+					t.Fatalf("unexpected events %+v", events)
+				}
+			},
+		},
+		{
+			name: "object options",
+			key:  "coll/options.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputCacheControl = "max-age=86400"
+				cfg.OutputStorageClass = "STANDARD_IA"
+				cfg.OutputMetadata = map[string]string{"source": "s3://{source_bucket}/{source_key}", "options": "{options_hash}"}
+				cfg.OutputTags = map[string]string{"lifecycle": "derivative", "rule": "{rule}"}
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				o := p.mustExist("out", "coll/options.jp2")
+				if o.opts.ContentType != "image/jp2" || o.opts.CacheControl != "max-age=86400" || o.storageClass != "STANDARD_IA" {
+					t.Fatalf("unexpected upload options %+v", o.opts)
+				}
+				if o.opts.Metadata["source"] != "s3://in/coll/options.tif" || o.opts.Metadata["options"] != job.OptionsHash ||
+					o.opts.Metadata[metadataSHA256] != testDataSHA256 {
+					t.Fatalf("unexpected metadata %v", o.opts.Metadata)
+				}
+				if len(o.tags) != 2 || o.tags["lifecycle"] != "derivative" || o.tags["rule"] != "test" {
+					t.Fatalf("unexpected tags %v", o.tags)
+				}
+			},
+		},
+		{
+			name: "filesystem",
+			key:  "coll/filesystem.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputBucket = ""
+				cfg.OutputFSRoot = t.TempDir()
+				cfg.OutputKeyPrefix = "iiif"
+				cfg.OutputSidecar = true
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				root := p.config.OutputFSRoot
+				buf, err := os.ReadFile(filepath.Join(root, "iiif/coll/filesystem.jp2"))
+				if err != nil || string(buf) != "image data" {
+					t.Fatalf("unexpected output %q (%v)", buf, err)
+				}
+
+				var sidecar OutputSidecar
+				buf, err = os.ReadFile(filepath.Join(root, "iiif/coll/filesystem.jp2.json"))
+				if err == nil {
+					err = json.Unmarshal(buf, &sidecar)
+				}
+				if err != nil || sidecar.Job != job.Id || sidecar.Size != 10 || sidecar.SHA256 != testDataSHA256 || sidecar.MD5 != "" {
+					t.Fatalf("unexpected sidecar %+v (%v)", sidecar, err)
+				}
+			},
+		},
+		{
+			name: "sharded filesystem",
+			key:  "coll/sharded.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputBucket = ""
+				cfg.OutputFSRoot = t.TempDir()
+				cfg.OutputFileMode = 0640
+				cfg.OutputDirMode = 0750
+				cfg.OutputGroup = strconv.Itoa(os.Getgid())
+				cfg.OutputShardDepth = 1
+				cfg.OutputShardWidth = 2
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				root := p.config.OutputFSRoot
+				name := p.config.targetName(Destination{FSRoot: root}, "coll/sharded.jp2")
+				if job.Output != filepath.Join(root, name) || len(strings.Split(name, "/")) != 3 {
+					t.Fatalf("unexpected output %s", job.Output)
+				}
+				fi, err := os.Stat(job.Output)
+				if err != nil || fi.Mode().Perm() != 0640 {
+					t.Fatalf("unexpected output mode %v (%v)", fi, err)
+				}
+				fi, err = os.Stat(filepath.Dir(job.Output))
+				if err != nil || fi.Mode().Perm() != 0750 {
+					t.Fatalf("unexpected directory mode %v (%v)", fi, err)
+				}
+			},
+		},
+		{
+			name: "multiple outputs",
+			key:  "coll/multiple.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputBucket = ""
+				cfg.OutputFSRoot = t.TempDir()
+				cfg.CompletionEvents = true
+				cfg.OutputTargets = []OutputTarget{
+					{Destination: Destination{Bucket: "preservation", KeyPrefix: "masters"}, Policy: policyRequired},
+					{Destination: Destination{FSRoot: unwritableRoot(t)}, Policy: policyBestEffort},
+				}
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				output := filepath.Join(p.config.OutputFSRoot, "coll/multiple.jp2")
+				buf, err := os.ReadFile(output)
+				if job.Output != output || err != nil || string(buf) != "image data" {
+					t.Fatalf("unexpected output %s %q (%v)", job.Output, buf, err)
+				}
+				o := p.mustExist("preservation", "masters/coll/multiple.jp2")
+				if string(o.data) != "image data" || o.opts.SHA256 != testDataSHA256 {
+					t.Fatalf("unexpected preservation copy %q %+v", o.data, o.opts)
+				}
+				events := p.events.all()
+				if len(events) != 1 || len(events[0].Outputs) != 2 || events[0].Outputs[1] != "s3://preservation/masters/coll/multiple.jp2" {
+					t.Fatalf("unexpected events %+v", events)
+				}
+			},
+		},
+		{
+			// the message is redelivered and the job retried
+			name: "required output failure",
+			key:  "coll/required.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputTargets = []OutputTarget{{Destination: Destination{FSRoot: unwritableRoot(t)}, Policy: policyRequired}}
+			},
+			outcome: jobFailed,
+			acked:   false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustExist("out", "coll/required.jp2")
+			},
+		},
+	})
+}
+
+func TestPipelineSourceDisposition(t *testing.T) {
+
+	runPipelineCases(t, []pipelineCase{
+		{
+			name: "delete",
+			key:  "coll/deleted.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.DeleteSource = true
+				cfg.SourceDisposition = dispositionDelete
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustNotExist("in", "coll/deleted.tif")
+				records := p.audit.all()
+				if len(records) != 1 {
+					t.Fatalf("expected 1 audit record, got %d", len(records))
+				}
+				rec := records[0]
+				if rec.Action != auditDeleted || rec.Verified == false || rec.SourceETag != "abc123" || rec.OutputSize != 10 ||
+					strings.HasPrefix(rec.SourceChecksum, "sha256:") == false {
+					t.Fatalf("unexpected audit record %+v", rec)
+				}
+			},
+		},
+		{
+			name: "archive",
+			key:  "coll/archived.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.SourceDisposition = dispositionArchive
+				cfg.ArchiveDestination = Destination{Bucket: "archive", KeyPrefix: "masters"}
+				cfg.ArchiveStorageClass = "DEEP_ARCHIVE"
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustNotExist("in", "coll/archived.tif")
+				o := p.mustExist("archive", "masters/coll/archived.tif")
+				if o.storageClass != "DEEP_ARCHIVE" {
+					t.Fatalf("unexpected storage class %s", o.storageClass)
+				}
+				records := p.audit.all()
+				if len(records) != 1 || records[0].Action != auditArchived || records[0].Archive != "s3://archive/masters/coll/archived.tif" {
+					t.Fatalf("unexpected audit records %+v", records)
+				}
+			},
+		},
+		{
+			name: "tag",
+			key:  "coll/tagged.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.SourceDisposition = dispositionTag
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				o := p.mustExist("in", "coll/tagged.tif")
+				if o.tags["iiif-ingest"] != "processed" {
+					t.Fatalf("unexpected tags %v", o.tags)
+				}
+			},
+		},
+	})
+}
+
+func TestPipelineStreaming(t *testing.T) {
+
+	runPipelineCases(t, []pipelineCase{
+		{
+			name: "conversion",
+			key:  "coll/streamed.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.ConvertStdin = "-"
+				cfg.ConvertStdout = "{suffix}:-"
+				cfg.DeleteSource = true
+				cfg.SourceDisposition = dispositionDelete
+				cfg.CompletionEvents = true
+			},
+			outcome: jobCompleted,
+			acked:   true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				o := p.mustExist("out", "coll/streamed.jp2")
+				if string(o.data) != "image data" {
+					t.Fatalf("unexpected output contents %q", o.data)
+				}
+				p.mustNotExist("in", "coll/streamed.tif")
+
+				// the audit record describes the streamed source
+				records := p.audit.all()
+				if len(records) != 1 || records[0].SourceSize != 10 || records[0].OutputSize != 10 ||
+					records[0].SourceChecksum != "sha256:"+testDataSHA256 {
+					t.Fatalf("unexpected audit records %+v", records)
+				}
+
+				// the streamed output checksum is computed as it is uploaded and added to the metadata
+				if o.opts.Metadata[metadataSHA256] != testDataSHA256 || o.opts.ContentType != "image/jp2" {
+					t.Fatalf("unexpected output options %+v", o.opts)
+				}
+				events := p.events.all()
+				if len(events) != 1 || events[0].Type != eventCompleted || events[0].OutputSHA256 != testDataSHA256 {
+					t.Fatalf("unexpected events %+v", events)
+				}
+			},
+		},
+		{
+			name:    "conversion failure",
+			key:     "coll/streamfail.tif",
+			content: "FAIL",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.ConvertStdin = "-"
+				cfg.ConvertStdout = "-"
+			},
+			outcome: jobFailed,
+			acked:   false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustExist("in", "coll/streamfail.tif")
+				p.mustNotExist("out", "coll/streamfail.jp2")
+				events := p.events.all()
+				if len(events) != 1 || events[0].Stage != "convert" {
+					t.Fatalf("unexpected events %+v", events)
+				}
+			},
+		},
+	})
+}
+
+func TestPipelineFailures(t *testing.T) {
+
+	quarantine := func(prefix string) func(t *testing.T, cfg *ServiceConfig) {
+		return func(t *testing.T, cfg *ServiceConfig) {
+			cfg.QuarantineDestination = Destination{Bucket: "quarantine", KeyPrefix: prefix}
+		}
+	}
+
+	runPipelineCases(t, []pipelineCase{
+		{
+			name: "deferred without disk space",
+			key:  "coll/deferred.tif",
+			services: func(svc *workerServices) {
+				svc.disk = newDiskBudget(fixedFree(5))
+			},
+			outcome: jobDeferred,
+			acked:   false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				if strings.Contains(job.Error, "insufficient work directory space") == false {
+					t.Fatalf("unexpected error %s", job.Error)
+				}
+				p.mustNotExist("out", "coll/deferred.jp2")
+				if len(p.events.all()) != 0 {
+					t.Fatal("expected no failure event")
+				}
+			},
+		},
+		{
+			// the size is determined from the store when the event does not include it
+			name:    "deferred without disk space for an unsized object",
+			key:     "coll/unsized.tif",
+			unsized: true,
+			services: func(svc *workerServices) {
+				svc.disk = newDiskBudget(fixedFree(5))
+			},
+			outcome: jobDeferred,
+			acked:   false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				if job.SourceSize != 10 {
+					t.Fatalf("expected the source size from the store, got %d", job.SourceSize)
+				}
+				p.mustNotExist("out", "coll/unsized.jp2")
+			},
+		},
+		{
+			name:    "conversion failure is retried",
+			key:     "coll/convertfail.tif",
+			content: "FAIL",
+			outcome: jobFailed,
+			acked:   false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				if len(job.Error) == 0 {
+					t.Fatal("expected the job error")
+				}
+				p.mustExist("in", "coll/convertfail.tif")
+				p.mustNotExist("out", "coll/convertfail.jp2")
+				if len(p.events.all()) != 1 {
+					t.Fatal("expected a failure event")
+				}
+			},
+		},
+		{
+			name:      "conversion failure is quarantined",
+			key:       "coll/quarantined.tif",
+			content:   "FAIL",
+			configure: quarantine("failed"),
+			outcome:   jobFailed,
+			acked:     true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustNotExist("in", "coll/quarantined.tif")
+				p.mustExist("quarantine", "failed/coll/quarantined.tif")
+				events := p.events.all()
+				if len(events) != 1 || events[0].Stage != "convert" || events[0].Quarantine != "s3://quarantine/failed/coll/quarantined.tif" {
+					t.Fatalf("unexpected events %+v", events)
+				}
+			},
+		},
+		{
+			name:      "killed conversion is retried",
+			key:       "coll/killed.tif",
+			content:   "KILL",
+			configure: quarantine("failed"),
+			outcome:   jobRetried,
+			acked:     false,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustExist("in", "coll/killed.tif")
+				p.mustNotExist("quarantine", "failed/coll/killed.tif")
+				if len(p.events.all()) != 0 {
+					t.Fatal("expected no failure event")
+				}
+			},
+		},
+		{
+			name:      "invalid name is quarantined",
+			key:       "coll/not a valid name.tif",
+			configure: quarantine("invalid"),
+			outcome:   jobFailed,
+			acked:     true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustNotExist("in", "coll/not a valid name.tif")
+				p.mustExist("quarantine", "invalid/coll/not a valid name.tif")
+
+				sidecar := p.mustExist("quarantine", "invalid/coll/not a valid name.tif.json")
+				var rec DeletionRecord
+				err := json.Unmarshal(sidecar.data, &rec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rec.Stage != "validate" || strings.Contains(rec.Error, "input filename is invalid") == false {
+					t.Fatalf("unexpected sidecar %+v", rec)
+				}
+
+				events := p.events.all()
+				if len(events) != 1 || events[0].Type != eventFailed || events[0].Stage != "validate" {
+					t.Fatalf("unexpected events %+v", events)
+				}
+				records := p.audit.all()
+				if len(records) != 1 || records[0].Action != auditQuarantined {
+					t.Fatalf("unexpected audit records %+v", records)
+				}
+			},
+		},
+		{
+			// the copy is verified against the size from the store when the event does not include it
+			name:      "unsized invalid name is quarantined",
+			key:       "coll/not a valid unsized name.tif",
+			unsized:   true,
+			configure: quarantine("invalid"),
+			outcome:   jobFailed,
+			acked:     true,
+			check: func(t *testing.T, p *testPipeline, job JobRecord) {
+				p.mustNotExist("in", "coll/not a valid unsized name.tif")
+				p.mustExist("quarantine", "invalid/coll/not a valid unsized name.tif")
+				records := p.audit.all()
+				if len(records) != 1 || records[0].Action != auditQuarantined || records[0].SourceSize != 10 {
+					t.Fatalf("unexpected audit records %+v", records)
+				}
+			},
+		},
+		{
+			// without quarantine a message that can never be processed is still removed from the queue
+			name:    "invalid name is acknowledged",
+			key:     "coll/not a valid name either.tif",
+			outcome: jobFailed,
+			acked:   true,
+			check:   unquarantinedFailure("coll/not a valid name either.tif", "validate"),
+		},
+		{
+			// a missing route is a configuration fault, the message is redelivered once it is fixed
+			name: "no destination is retried",
+			key:  "coll/unrouted.tif",
+			configure: func(t *testing.T, cfg *ServiceConfig) {
+				cfg.OutputBucket = ""
+			},
+			outcome: jobFailed,
+			acked:   false,
+			check:   unquarantinedFailure("coll/unrouted.tif", "route"),
+		},
+	})
+}
+
+// check a failure without quarantine left the source alone and emitted a failure event
+func unquarantinedFailure(key string, stage string) func(t *testing.T, p *testPipeline, job JobRecord) {
+	return func(t *testing.T, p *testPipeline, job JobRecord) {
+		p.mustExist("in", key)
+		events := p.events.all()
+		if len(events) != 1 || events[0].Type != eventFailed || events[0].Stage != stage || len(events[0].Quarantine) != 0 {
+			t.Fatalf("unexpected events %+v", events)
+		}
+	}
+}
+
+//
+// end of file
+//